@@ -1,5 +1,11 @@
 package client
 
+import (
+	"time"
+
+	"github.com/qiancijun/minirpc/metadata"
+)
+
 type Call struct {
 	Seq           uint64
 	ServiceMethod string // 形如 <service>.<method>
@@ -7,6 +13,9 @@ type Call struct {
 	Reply         interface{}
 	Error         error
 	Done          chan *Call
+	Trailer       metadata.MD // 服务端在响应中设置的 trailer
+	deadline      time.Time   // 调用方 context 的截止时间，零值表示没有
+	metadata      metadata.MD // 随请求发送的元数据
 }
 
 func (call *Call) done() {
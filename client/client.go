@@ -3,6 +3,7 @@ package client
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,11 +13,15 @@ import (
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/qiancijun/minirpc/codec"
 	"github.com/qiancijun/minirpc/common"
 	"github.com/qiancijun/minirpc/errs"
+	"github.com/qiancijun/minirpc/metadata"
+	"github.com/qiancijun/minirpc/metrics"
+	"github.com/qiancijun/minirpc/trace"
 )
 
 type Client struct {
@@ -27,8 +32,18 @@ type Client struct {
 	mu       sync.Mutex
 	seq      uint64
 	pending  map[uint64]*Call
+	streams  map[uint64]*Stream
 	closing  bool
 	shutdown bool
+	goAway   bool // 服务端即将关闭连接，不再发起新的调用
+
+	disconnected chan struct{} // 接收循环退出、所有调用都已终止后关闭
+	lastRead     atomic.Int64  // 最近一次收到消息的时间（UnixNano），心跳据此判断连接是否存活
+	expired      atomic.Bool   // 连接因为心跳超时被关闭
+
+	capabilities []string                 // 握手时服务端声明的能力
+	interceptors []UnaryClientInterceptor // Call 的拦截器链
+	metrics      *metrics.RPC
 }
 
 type clientResult struct {
@@ -39,21 +54,49 @@ type clientResult struct {
 type newClientFunc func(conn net.Conn, opt *common.Option) (client *Client, err error)
 
 func NewClient(conn net.Conn, opt *common.Option) (*Client, error) {
-	f := codec.NewCodecFuncMap[opt.CodecType]
-	if f == nil {
-		err := fmt.Errorf("invalid codec type %s", opt.CodecType)
-		log.Println("rpc client: codec error: ", err)
-		return nil, err
-	}
-
+	// 统计连接上的字节数，握手失败时通过 rwc 关闭连接
+	rwc := metrics.NewRPC(opt.Metrics, "client").Conn(conn)
 	// 发送 Option 协商
-	if err := json.NewEncoder(conn).Encode(opt); err != nil {
+	if err := json.NewEncoder(rwc).Encode(opt); err != nil {
 		log.Println("rpc client: options error: ", err)
-		_ = conn.Close()
+		_ = rwc.Close()
 		return nil, err
 	}
 
-	return newClientCodec(f(conn), opt), nil
+	// 等待服务端的握手应答，旧版流式协议没有应答
+	resp := &common.HandshakeResponse{CodecType: opt.CodecType, Version: opt.Version}
+	if opt.Version != 0 {
+		dec := json.NewDecoder(rwc)
+		if err := dec.Decode(resp); err != nil {
+			log.Println("rpc client: handshake error: ", err)
+			_ = rwc.Close()
+			return nil, err
+		}
+		if err := resp.Err(); err != nil {
+			log.Println("rpc client: handshake rejected: ", resp.Message)
+			_ = rwc.Close()
+			return nil, err
+		}
+		rwc = common.NewHandshakeConn(rwc, dec.Buffered())
+	}
+
+	cc := codec.NewCodec(rwc, resp.CodecType, resp.Version, opt.MaxMessageSize)
+	if cc == nil {
+		err := fmt.Errorf("invalid codec type %s or version %d", resp.CodecType, resp.Version)
+		log.Println("rpc client: codec error: ", err)
+		_ = rwc.Close()
+		return nil, err
+	}
+	client := newClientCodec(cc, opt)
+	client.capabilities = resp.Capabilities
+	if opt.KeepaliveInterval > 0 && client.hasCapability(common.CapabilityKeepalive) {
+		timeout := opt.KeepaliveTimeout
+		if timeout == 0 {
+			timeout = common.DefaultKeepaliveTimeout
+		}
+		go client.keepalive(opt.KeepaliveInterval, timeout)
+	}
+	return client, nil
 }
 
 func NewHTTPClient(conn net.Conn, opt *common.Option) (*Client, error) {
@@ -97,6 +140,20 @@ func DialHTTP(network, address string, opts ...*common.Option) (*Client, error)
 	return dialTimeout(NewHTTPClient, network, address, opts...)
 }
 
+// DialTLS 通过 TLS 连接服务端，Option 中没有 TLSConfig 时使用默认配置校验服务端证书
+func DialTLS(network, address string, opts ...*common.Option) (*Client, error) {
+	opt, err := parseOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if opt.TLSConfig == nil {
+		o := *opt
+		o.TLSConfig = &tls.Config{}
+		opt = &o
+	}
+	return dialTimeout(NewClient, network, address, opt)
+}
+
 func XDial(rpcAddr string, opts ...*common.Option) (*Client, error) {
 	parts := strings.Split(rpcAddr, "@")
 	if len(parts) != 2 {
@@ -106,6 +163,8 @@ func XDial(rpcAddr string, opts ...*common.Option) (*Client, error) {
 	switch protocol {
 	case "http":
 		return DialHTTP("tcp", addr, opts...)
+	case "tls":
+		return DialTLS("tcp", addr, opts...)
 	default:
 		return Dial(protocol, addr, opts...)
 	}
@@ -117,7 +176,12 @@ func newClientCodec(cc codec.Codec, opt *common.Option) *Client {
 		cc:      cc,
 		opt:     opt,
 		pending: make(map[uint64]*Call),
+		streams: make(map[uint64]*Stream),
+		metrics: metrics.NewRPC(opt.Metrics, "client"),
+
+		disconnected: make(chan struct{}),
 	}
+	client.lastRead.Store(time.Now().UnixNano())
 	go client.receive()
 	return client
 }
@@ -127,7 +191,7 @@ func dialTimeout(f newClientFunc, network, address string, opts ...*common.Optio
 	if err != nil {
 		return nil, err
 	}
-	conn, err := net.DialTimeout(network, address, opt.ConnectTimeout)
+	conn, err := dialConn(network, address, opt)
 	if err != nil {
 		return nil, err
 	}
@@ -152,12 +216,27 @@ func dialTimeout(f newClientFunc, network, address string, opts ...*common.Optio
 	}
 	select {
 	case <-time.After(opt.ConnectTimeout):
+		// 握手可能在超时后才完成，此时关闭客户端释放连接，连接数才能正确减少
+		go func() {
+			if result := <-ch; result.client != nil {
+				_ = result.client.Close()
+			}
+		}()
 		return nil, errs.ErrClientConnectTimeout
 	case result := <-ch:
 		return result.client, result.err
 	}
 }
 
+// dialConn 建立底层连接，配置了 TLSConfig 时超时时间同时包含 TLS 握手
+func dialConn(network, address string, opt *common.Option) (net.Conn, error) {
+	if opt.TLSConfig == nil {
+		return net.DialTimeout(network, address, opt.ConnectTimeout)
+	}
+	dialer := &net.Dialer{Timeout: opt.ConnectTimeout}
+	return tls.DialWithDialer(dialer, network, address, opt.TLSConfig)
+}
+
 func parseOptions(opts ...*common.Option) (*common.Option, error) {
 	if len(opts) == 0 || opts[0] == nil {
 		return common.DefaultOption, nil
@@ -166,18 +245,23 @@ func parseOptions(opts ...*common.Option) (*common.Option, error) {
 		return nil, errs.ErrOptionsEmpty
 	}
 	opt := opts[0]
-	log.Println(opt)
 	opt.MagicNumber = common.DefaultOption.MagicNumber
 	if opt.CodecType == "" {
 		opt.CodecType = common.DefaultOption.CodecType
 	}
+	switch {
+	case opt.Unframed:
+		opt.Version = 0
+	case opt.Version == 0:
+		opt.Version = common.DefaultOption.Version
+	}
 	return opt, nil
 }
 
 func (c *Client) registerCall(call *Call) (uint64, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	if c.closing || c.shutdown {
+	if c.closing || c.shutdown || c.goAway {
 		return 0, errs.ErrShutdown
 	}
 	call.Seq = c.seq
@@ -204,6 +288,10 @@ func (c *Client) terminateCalls(err error) {
 		call.Error = err
 		call.done()
 	}
+	for seq, s := range c.streams {
+		delete(c.streams, seq)
+		s.finish(err)
+	}
 }
 
 func (c *Client) receive() {
@@ -213,12 +301,37 @@ func (c *Client) receive() {
 		if err = c.cc.ReadHeader(&h); err != nil {
 			break
 		}
+		c.lastRead.Store(time.Now().UnixNano())
+		switch h.Type {
+		case codec.MessageGoAway:
+			// 已发出的调用仍会正常收到响应
+			c.mu.Lock()
+			c.goAway = true
+			c.mu.Unlock()
+			err = c.cc.ReadBody(nil)
+			continue
+		case codec.MessagePing:
+			if err = c.cc.ReadBody(nil); err == nil {
+				c.sendPong(h.Seq)
+			}
+			continue
+		case codec.MessagePong:
+			err = c.cc.ReadBody(nil)
+			continue
+		}
+		var isStream bool
+		if isStream, err = c.receiveStream(&h); isStream {
+			continue
+		}
 		call := c.removeCall(h.Seq)
+		if call != nil {
+			call.Trailer = h.Metadata
+		}
 		switch {
 		case call == nil:
 			err = c.cc.ReadBody(nil)
-		case h.Error != "":
-			call.Error = fmt.Errorf("%s", h.Error)
+		case h.Error != "" || h.Code != uint32(errs.CodeOK):
+			call.Error = statusFromHeader(&h)
 			err = c.cc.ReadBody(nil)
 			call.done()
 		default:
@@ -229,8 +342,62 @@ func (c *Client) receive() {
 			call.done()
 		}
 	}
+	if c.expired.Load() {
+		err = errs.ErrKeepaliveTimeout
+	}
 	// 发生错误了，终止所有的 call
 	c.terminateCalls(err)
+	close(c.disconnected)
+}
+
+// statusFromHeader 还原服务端返回的错误，旧版服务端只返回错误信息，错误码视为 CodeUnknown
+func statusFromHeader(h *codec.Header) *errs.Status {
+	code := errs.Code(h.Code)
+	if code == errs.CodeOK {
+		code = errs.CodeUnknown
+	}
+	return &errs.Status{Code: code, Message: h.Error, Details: h.Details}
+}
+
+// IsConnectionError 判断 Call 返回的错误是否来自连接，而不是服务端的方法或调用方的 ctx。
+// 这类错误说明请求没有发出、服务端在关闭前拒绝了请求，或者连接在等待响应时断开，
+// 换一个连接重新发送通常可以成功；注意最后一种情况下请求可能已经在服务端执行过，
+// 只有幂等的方法才能重试，其他方法用 IsUnsentError 判断
+func IsConnectionError(err error) bool {
+	if err == nil || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var st *errs.Status
+	if errors.As(err, &st) {
+		// 服务端返回的错误中只有关闭时拒绝的请求没有执行
+		return errors.Is(err, errs.ErrServerShutdown)
+	}
+	switch {
+	case errors.Is(err, errs.ErrShutdown), errors.Is(err, errs.ErrClientDisconnected),
+		errors.Is(err, errs.ErrKeepaliveTimeout), errors.Is(err, errs.ErrClientConnectTimeout),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, net.ErrClosed):
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne)
+}
+
+// IsUnsentError 判断 Call 返回的错误是否说明服务端没有执行请求：拨号失败、连接已经关闭或收到 go away
+// 而没有发出请求，或者服务端在关闭时拒绝了请求。这类错误对任何方法都可以安全地重试
+func IsUnsentError(err error) bool {
+	if err == nil || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var st *errs.Status
+	if errors.As(err, &st) {
+		return errors.Is(err, errs.ErrServerShutdown)
+	}
+	if errors.Is(err, errs.ErrShutdown) || errors.Is(err, errs.ErrClientDisconnected) ||
+		errors.Is(err, errs.ErrClientConnectTimeout) {
+		return true
+	}
+	var oe *net.OpError
+	return errors.As(err, &oe) && oe.Op == "dial"
 }
 
 func (c *Client) send(call *Call) {
@@ -249,6 +416,12 @@ func (c *Client) send(call *Call) {
 	c.header.ServiceMethod = call.ServiceMethod
 	c.header.Seq = seq
 	c.header.Error = ""
+	c.header.Timeout = 0
+	c.header.Metadata = call.metadata
+	if !call.deadline.IsZero() {
+		// 发送剩余时间而不是绝对时间，避免两端时钟不一致
+		c.header.Timeout = max(int64(time.Until(call.deadline)), 1)
+	}
 	// log.Println(c.header)
 	// 发送请求
 	if err := c.cc.Write(&c.header, call.Args); err != nil {
@@ -260,7 +433,28 @@ func (c *Client) send(call *Call) {
 	}
 }
 
+// sendCancel 通知服务端取消序列号为 seq 的请求，服务端不支持时忽略
+func (c *Client) sendCancel(seq uint64) {
+	if !c.hasCapability(common.CapabilityCancel) {
+		return
+	}
+	c.sending.Lock()
+	defer c.sending.Unlock()
+	h := &codec.Header{
+		Seq:  seq,
+		Type: codec.MessageCancel,
+	}
+	if err := c.cc.Write(h, struct{}{}); err != nil {
+		log.Println("rpc client: send cancel error: ", err)
+	}
+}
+
 func (c *Client) Go(serviceMethod string, args, reply interface{}, done chan *Call) *Call {
+	return c.goContext(context.Background(), serviceMethod, args, reply, done)
+}
+
+// goContext 与 Go 相同，同时将 ctx 的截止时间随请求发送给服务端
+func (c *Client) goContext(ctx context.Context, serviceMethod string, args, reply interface{}, done chan *Call) *Call {
 	if done == nil {
 		done = make(chan *Call, 10)
 	} else if cap(done) == 0 {
@@ -272,23 +466,103 @@ func (c *Client) Go(serviceMethod string, args, reply interface{}, done chan *Ca
 		Reply:         reply,
 		Done:          done,
 	}
+	if deadline, ok := ctx.Deadline(); ok {
+		call.deadline = deadline
+	}
+	if md, ok := metadata.FromOutgoingContext(ctx); ok {
+		call.metadata = md
+	}
 	c.send(call)
 	return call
 }
 
+// Use 为 Call 与 Notify 追加拦截器，先追加的位于外层；需要在发起调用前设置，Go 发起的异步调用不经过拦截器
+func (c *Client) Use(interceptors ...UnaryClientInterceptor) {
+	c.interceptors = append(c.interceptors, interceptors...)
+}
+
 func (c *Client) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	// call := <-c.Go(serviceMethod, args, reply, make(chan *Call, 1)).Done
-	call := c.Go(serviceMethod, args, reply, make(chan *Call, 1))
+	if len(c.interceptors) == 0 {
+		return c.invoke(ctx, serviceMethod, args, reply)
+	}
+	return ChainInvoker(c.interceptors, c.invoke)(ctx, serviceMethod, args, reply)
+}
+
+func (c *Client) invoke(ctx context.Context, serviceMethod string, args, reply interface{}) (err error) {
+	finish := c.metrics.Begin(serviceMethod)
+	ctx, span := c.opt.Tracer.Start(ctx, serviceMethod, trace.KindClient)
+	defer func() {
+		finish(err)
+		span.Finish(err)
+	}()
+	ctx = trace.Inject(ctx)
+	call := c.goContext(ctx, serviceMethod, args, reply, make(chan *Call, 1))
 	select {
 	case <-ctx.Done():
-		// 调用超时
-		c.removeCall(call.Seq)
-		return errs.ErrClientCallTimeout
+		// 调用超时或被取消，通知服务端不必继续处理
+		if c.removeCall(call.Seq) != nil {
+			c.sendCancel(call.Seq)
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			return errs.ErrClientCallTimeout
+		}
+		return errs.ErrClientCallCanceled
 	case call := <-call.Done:
+		if call.Trailer != nil {
+			metadata.DeliverTrailer(ctx, call.Trailer)
+		}
 		return call.Error
 	}
 }
 
+// Notify 发起单向调用：服务端执行方法但不发送响应，客户端也不登记等待。
+// 返回的错误只表示请求没能发出，方法本身的结果无从得知；拦截器看到的 reply 为 nil
+func (c *Client) Notify(ctx context.Context, serviceMethod string, args interface{}) error {
+	if len(c.interceptors) == 0 {
+		return c.notify(ctx, serviceMethod, args, nil)
+	}
+	return ChainInvoker(c.interceptors, c.notify)(ctx, serviceMethod, args, nil)
+}
+
+func (c *Client) notify(ctx context.Context, serviceMethod string, args, _ interface{}) (err error) {
+	finish := c.metrics.Begin(serviceMethod)
+	c.metrics.OneWay(serviceMethod)
+	ctx, span := c.opt.Tracer.Start(ctx, serviceMethod, trace.KindClient)
+	defer func() {
+		finish(err)
+		span.Finish(err)
+	}()
+	return c.sendOneWay(trace.Inject(ctx), serviceMethod, args)
+}
+
+// sendOneWay 发送单向请求，仍然占用一个序列号以便服务端登记与取消
+func (c *Client) sendOneWay(ctx context.Context, serviceMethod string, args interface{}) error {
+	c.sending.Lock()
+	defer c.sending.Unlock()
+
+	c.mu.Lock()
+	if c.closing || c.shutdown || c.goAway {
+		c.mu.Unlock()
+		return errs.ErrShutdown
+	}
+	seq := c.seq
+	c.seq++
+	c.mu.Unlock()
+
+	h := &codec.Header{
+		ServiceMethod: serviceMethod,
+		Seq:           seq,
+		OneWay:        true,
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		h.Timeout = max(int64(time.Until(deadline)), 1)
+	}
+	if md, ok := metadata.FromOutgoingContext(ctx); ok {
+		h.Metadata = md
+	}
+	return c.cc.Write(h, args)
+}
+
 // Close implements io.Closer.
 func (c *Client) Close() error {
 	c.mu.Lock()
@@ -300,10 +574,39 @@ func (c *Client) Close() error {
 	return c.cc.Close()
 }
 
+// ServerCapabilities 返回握手时服务端声明的能力
+func (c *Client) ServerCapabilities() []string {
+	return c.capabilities
+}
+
+func (c *Client) hasCapability(capability string) bool {
+	for _, cp := range c.capabilities {
+		if cp == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// Pending 返回连接上等待响应的调用与进行中的流的数量，连接池据此选择最空闲的连接
+func (c *Client) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pending) + len(c.streams)
+}
+
+// IsAvailable 判断连接是否仍然存活，收到 go away 后连接仍会等待已发出的调用完成
 func (c *Client) IsAvailable() bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	return !c.shutdown && !c.closing
 }
 
+// AcceptingCalls 判断连接能否发起新的调用，收到 go away 之后返回 false，用来挑选发起调用的连接
+func (c *Client) AcceptingCalls() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return !c.shutdown && !c.closing && !c.goAway
+}
+
 var _ io.Closer = (*Client)(nil)
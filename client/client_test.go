@@ -1,18 +1,26 @@
 package client
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"io"
 	"net"
+	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
 	"testing"
 	"time"
 
+	"github.com/qiancijun/minirpc/codec"
 	"github.com/qiancijun/minirpc/common"
 	"github.com/qiancijun/minirpc/errs"
+	"github.com/qiancijun/minirpc/metadata"
+	"github.com/qiancijun/minirpc/metrics"
 	"github.com/qiancijun/minirpc/server"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestClient_dialTimeout(t *testing.T) {
@@ -33,6 +41,30 @@ func TestClient_dialTimeout(t *testing.T) {
 		_, err := dialTimeout(f, "tcp", l.Addr().String(), &common.Option{ConnectTimeout: 0})
 		assert.NoError(t, err)
 	})
+	t.Run("handshake after timeout", func(t *testing.T) {
+		s := server.NewServer()
+		sl, _ := net.Listen("tcp", ":0")
+		go s.Accept(sl, server.DefaultServerOption)
+		defer s.Close()
+
+		// 超时后才完成握手的客户端会被关闭，连接数回到 0
+		r := metrics.NewRegistry()
+		done := make(chan struct{})
+		late := func(_ net.Conn, opt *common.Option) (*Client, error) {
+			defer close(done)
+			time.Sleep(100 * time.Millisecond)
+			conn, err := net.Dial("tcp", sl.Addr().String())
+			if err != nil {
+				return nil, err
+			}
+			return NewClient(conn, opt)
+		}
+		_, err := dialTimeout(late, "tcp", l.Addr().String(), &common.Option{ConnectTimeout: 50 * time.Millisecond, Metrics: r})
+		assert.ErrorIs(t, err, errs.ErrClientConnectTimeout)
+		<-done
+		connections := r.Gauge("minirpc_client_connections", "").With()
+		assert.Eventually(t, func() bool { return connections.Value() == 0 }, time.Second, 10*time.Millisecond)
+	})
 }
 
 type Bar int
@@ -42,6 +74,23 @@ func (b Bar) Timeout(argv int, reply *int) error {
 	return nil
 }
 
+func (b Bar) Double(argv int, reply *int) error {
+	*reply = argv * 2
+	return nil
+}
+
+// Echo 返回请求元数据中 key 对应的值，并通过 trailer 回传
+func (b Bar) Echo(ctx context.Context, key string, reply *string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	*reply = md.Get(key)
+	return metadata.SetTrailer(ctx, metadata.Pairs("echo", md.Get(key)))
+}
+
+// Fail 返回带有错误码与结构化信息的应用错误
+func (b Bar) Fail(field string, reply *int) error {
+	return errs.NewStatus(errs.CodeInvalidArgument, "invalid field").WithDetails(map[string]string{"field": field})
+}
+
 func startServer(addr chan string) {
 	var b Bar
 	_ = server.Register(&b)
@@ -67,6 +116,14 @@ func TestClient_Call(t *testing.T) {
 		assert.NotNil(t, err)
 		assert.Equal(t, err.Error(), errs.ErrClientCallTimeout.Error())
 	})
+	t.Run("client cancel", func(t *testing.T) {
+		client, _ := Dial("tcp", addr)
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(100*time.Millisecond, cancel)
+		var reply int
+		err := client.Call(ctx, "Bar.Timeout", 1, &reply)
+		assert.Equal(t, errs.ErrClientCallCanceled, err)
+	})
 	t.Run("server handle timeout", func(t *testing.T) {
 		client, _ := Dial("tcp", addr, &common.Option{
 			HandleTimeout: time.Second,
@@ -76,6 +133,28 @@ func TestClient_Call(t *testing.T) {
 		assert.NotNil(t, err)
 		assert.Equal(t, errs.ErrServiceHandleTimeout.Error(), err.Error())
 	})
+	t.Run("json codec", func(t *testing.T) {
+		client, err := Dial("tcp", addr, &common.Option{
+			CodecType: codec.JsonType,
+		})
+		assert.NoError(t, err)
+		var reply int
+		err = client.Call(context.Background(), "Bar.Double", 21, &reply)
+		assert.NoError(t, err)
+		assert.Equal(t, 42, reply)
+	})
+	t.Run("unframed json codec", func(t *testing.T) {
+		client, err := Dial("tcp", addr, &common.Option{
+			CodecType: codec.JsonType,
+			Unframed:  true,
+		})
+		require.NoError(t, err)
+		defer func() { _ = client.Close() }()
+		var reply int
+		err = client.Call(context.Background(), "Bar.Double", 21, &reply)
+		assert.NoError(t, err)
+		assert.Equal(t, 42, reply)
+	})
 }
 
 func TestXDial(t *testing.T) {
@@ -87,21 +166,355 @@ func TestXDial(t *testing.T) {
 		tempDir := os.TempDir()
 		addr := filepath.Join(tempDir, "geerpc.sock")
 
+		_ = os.Remove(addr)
+		l, err := net.Listen("unix", addr)
+		if err != nil {
+			t.Fatal("failed to listen unix socket")
+		}
 		go func() {
-			_ = os.Remove(addr)
-			l, err := net.Listen("unix", addr)
-			if err != nil {
-				t.Fatal("failed to listen unix socket")
-			}
 			ch <- struct{}{}
 			server.Accept(l, server.DefaultServerOption)
 		}()
 
 		<-ch
-		_, err := XDial("unix@" + addr)
+		_, err = XDial("unix@" + addr)
 		assert.NoError(t, err)
 
 		// 测试完成后清理
 		_ = os.Remove(addr)
 	}
 }
+
+func TestClient_Handshake(t *testing.T) {
+	t.Parallel()
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l, server.DefaultServerOption)
+
+	newClient := func(opt *common.Option) (*Client, error) {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			return nil, err
+		}
+		return NewClient(conn, opt)
+	}
+
+	t.Run("accepted", func(t *testing.T) {
+		client, err := Dial("tcp", l.Addr().String())
+		assert.NoError(t, err)
+		assert.Contains(t, client.ServerCapabilities(), common.CapabilityFrame)
+	})
+	t.Run("invalid magic number", func(t *testing.T) {
+		_, err := newClient(&common.Option{MagicNumber: 1, CodecType: codec.GobType, Version: codec.FrameVersion})
+		assert.Equal(t, errs.ErrInvalidMagicNumber, err)
+	})
+	t.Run("unsupported version", func(t *testing.T) {
+		_, err := newClient(&common.Option{MagicNumber: common.MagicNumber, CodecType: codec.GobType, Version: codec.FrameVersion + 1})
+		assert.Equal(t, errs.ErrUnsupportedVersion, err)
+	})
+	t.Run("unsupported codec", func(t *testing.T) {
+		_, err := newClient(&common.Option{MagicNumber: common.MagicNumber, CodecType: "application/xml", Version: codec.FrameVersion})
+		assert.Equal(t, errs.ErrUnsupportedCodec, err)
+	})
+}
+
+func TestClient_Metadata(t *testing.T) {
+	t.Parallel()
+	s := server.NewServer()
+	_ = s.Register(new(Bar))
+	tcp, _ := net.Listen("tcp", ":0")
+	go s.Accept(tcp, server.DefaultServerOption)
+	httpl, _ := net.Listen("tcp", ":0")
+	go func() { _ = http.Serve(httpl, s) }()
+
+	transports := map[string]func() (*Client, error){
+		"tcp":  func() (*Client, error) { return Dial("tcp", tcp.Addr().String()) },
+		"http": func() (*Client, error) { return DialHTTP("tcp", httpl.Addr().String()) },
+	}
+	for name, dial := range transports {
+		t.Run(name, func(t *testing.T) {
+			client, err := dial()
+			assert.NoError(t, err)
+			defer func() { _ = client.Close() }()
+
+			var trailer metadata.MD
+			ctx := metadata.AppendToOutgoingContext(context.Background(), "trace-id", name)
+			ctx = metadata.WithTrailer(ctx, &trailer)
+			var reply string
+			err = client.Call(ctx, "Bar.Echo", "trace-id", &reply)
+			assert.NoError(t, err)
+			assert.Equal(t, name, reply)
+			assert.Equal(t, name, trailer.Get("echo"))
+		})
+	}
+}
+
+// Relay 用收到的 ctx 把请求元数据转发给下游的 Bar.Echo，自己不设置 trailer
+type Relay struct{ down *Client }
+
+func (r *Relay) Forward(ctx context.Context, key string, reply *string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	return r.down.Call(metadata.NewOutgoingContext(ctx, md), "Bar.Echo", key, reply)
+}
+
+func TestClient_TrailerNotLeaked(t *testing.T) {
+	t.Parallel()
+	down := server.NewServer()
+	_ = down.Register(new(Bar))
+	dl, _ := net.Listen("tcp", ":0")
+	go down.Accept(dl, server.DefaultServerOption)
+	downClient, err := Dial("tcp", dl.Addr().String())
+	require.NoError(t, err)
+	defer func() { _ = downClient.Close() }()
+
+	up := server.NewServer()
+	_ = up.Register(&Relay{down: downClient})
+	ul, _ := net.Listen("tcp", ":0")
+	go up.Accept(ul, server.DefaultServerOption)
+	client, err := Dial("tcp", ul.Addr().String())
+	require.NoError(t, err)
+	defer func() { _ = client.Close() }()
+
+	// 下游返回的 trailer 不会混入 Relay 的响应
+	var trailer metadata.MD
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "trace-id", "relay")
+	ctx = metadata.WithTrailer(ctx, &trailer)
+	var reply string
+	require.NoError(t, client.Call(ctx, "Relay.Forward", "trace-id", &reply))
+	assert.Equal(t, "relay", reply)
+	assert.Empty(t, trailer)
+}
+
+func TestClient_Interceptor(t *testing.T) {
+	t.Parallel()
+	s := server.NewServer()
+	_ = s.Register(new(Bar))
+	l, _ := net.Listen("tcp", ":0")
+	go s.Accept(l, server.DefaultServerOption)
+
+	client, err := Dial("tcp", l.Addr().String())
+	assert.NoError(t, err)
+	defer func() { _ = client.Close() }()
+
+	var order []string
+	record := func(name string) UnaryClientInterceptor {
+		return func(ctx context.Context, serviceMethod string, args, reply interface{}, invoker UnaryInvoker) error {
+			order = append(order, name+" before "+serviceMethod)
+			err := invoker(metadata.AppendToOutgoingContext(ctx, "trace-id", name), serviceMethod, args, reply)
+			order = append(order, name+" after")
+			return err
+		}
+	}
+	client.Use(record("a"), record("b"))
+
+	var reply string
+	err = client.Call(context.Background(), "Bar.Echo", "trace-id", &reply)
+	assert.NoError(t, err)
+	// 内层拦截器设置的元数据覆盖外层
+	assert.Equal(t, "b", reply)
+	assert.Equal(t, []string{"a before Bar.Echo", "b before Bar.Echo", "b after", "a after"}, order)
+}
+
+func TestClient_GoAway(t *testing.T) {
+	t.Parallel()
+	s := server.NewServer()
+	_ = s.Register(new(Bar))
+	l, _ := net.Listen("tcp", ":0")
+	go s.Accept(l, server.DefaultServerOption)
+
+	client, err := Dial("tcp", l.Addr().String())
+	assert.NoError(t, err)
+	call := client.Go("Bar.Timeout", 1, new(int), nil)
+	time.Sleep(100 * time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() { done <- s.Shutdown(context.Background()) }()
+	time.Sleep(100 * time.Millisecond)
+	assert.False(t, client.AcceptingCalls(), "client should stop sending after go away")
+	assert.True(t, client.IsAvailable(), "the connection stays open while calls drain")
+	err = client.Call(context.Background(), "Bar.Double", 1, new(int))
+	assert.Equal(t, errs.ErrShutdown, err)
+
+	// 已发出的调用仍然得到响应
+	<-call.Done
+	assert.NoError(t, call.Error)
+	assert.NoError(t, <-done)
+}
+
+func TestClient_Status(t *testing.T) {
+	t.Parallel()
+	s := server.NewServer()
+	_ = s.Register(new(Bar))
+	l, _ := net.Listen("tcp", ":0")
+	go s.Accept(l, server.DefaultServerOption)
+
+	client, err := Dial("tcp", l.Addr().String())
+	assert.NoError(t, err)
+	defer func() { _ = client.Close() }()
+
+	t.Run("service not found", func(t *testing.T) {
+		err := client.Call(context.Background(), "Bar.Missing", 1, new(int))
+		assert.True(t, errors.Is(err, errs.ErrServiceNotFound))
+		assert.False(t, errors.Is(err, errs.ErrServiceIllFormed))
+		assert.Equal(t, errs.CodeNotFound, errs.CodeOf(err))
+	})
+	t.Run("application error", func(t *testing.T) {
+		err := client.Call(context.Background(), "Bar.Fail", "name", new(int))
+		var st *errs.Status
+		assert.True(t, errors.As(err, &st))
+		assert.Equal(t, errs.CodeInvalidArgument, st.Code)
+		assert.Equal(t, "invalid field", st.Message)
+		assert.Equal(t, "name", st.Details["field"])
+	})
+}
+
+func TestIsConnectionError(t *testing.T) {
+	t.Parallel()
+	s := server.NewServer()
+	_ = s.Register(new(Bar))
+	l, _ := net.Listen("tcp", ":0")
+	go s.Accept(l, server.DefaultServerOption)
+	client, err := Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+
+	// 服务端方法返回的错误
+	err = client.Call(context.Background(), "Bar.Fail", "name", new(int))
+	assert.False(t, IsConnectionError(err))
+	err = client.Call(context.Background(), "Bar.Missing", 1, new(int))
+	assert.False(t, IsConnectionError(err))
+	// 调用方的 ctx 结束
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = client.Call(ctx, "Bar.Timeout", 1, new(int))
+	assert.False(t, IsConnectionError(err))
+	assert.False(t, IsConnectionError(context.DeadlineExceeded))
+
+	// 连接关闭后的调用与无法建立的连接
+	_ = client.Close()
+	err = client.Call(context.Background(), "Bar.Double", 1, new(int))
+	assert.True(t, IsConnectionError(err))
+	assert.True(t, IsUnsentError(err))
+	_ = l.Close()
+	_, err = Dial("tcp", l.Addr().String())
+	assert.True(t, IsConnectionError(err))
+	assert.True(t, IsUnsentError(err))
+
+	// 等待响应时连接断开，请求可能已经执行过
+	for _, err := range []error{io.EOF, io.ErrUnexpectedEOF, errs.ErrKeepaliveTimeout} {
+		assert.True(t, IsConnectionError(err), err)
+		assert.False(t, IsUnsentError(err), err)
+	}
+	shutdown := errs.NewStatus(errs.CodeUnavailable, errs.ErrServerShutdown.Error())
+	assert.True(t, IsConnectionError(shutdown))
+	assert.True(t, IsUnsentError(shutdown))
+	for _, err := range []error{nil, errors.New("boom"), errs.NewStatus(errs.CodeUnavailable, "overloaded"), context.Canceled} {
+		assert.False(t, IsConnectionError(err), err)
+		assert.False(t, IsUnsentError(err), err)
+	}
+}
+
+func TestClient_Metrics(t *testing.T) {
+	t.Parallel()
+	serverMetrics, clientMetrics := metrics.NewRegistry(), metrics.NewRegistry()
+	s := server.NewServer()
+	_ = s.Register(new(Bar))
+	l, _ := net.Listen("tcp", ":0")
+	go s.Accept(l, server.ServerOption{Timeout: 100 * time.Millisecond, Metrics: serverMetrics})
+
+	client, err := Dial("tcp", l.Addr().String(), &common.Option{Metrics: clientMetrics})
+	assert.NoError(t, err)
+	connections := clientMetrics.Gauge("minirpc_client_connections", "").With()
+	assert.Equal(t, float64(1), connections.Value())
+
+	assert.NoError(t, client.Call(context.Background(), "Bar.Double", 1, new(int)))
+	assert.ErrorIs(t, client.Call(context.Background(), "Bar.Timeout", 1, new(int)), errs.ErrServiceHandleTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, client.Call(ctx, "Bar.Timeout", 1, new(int)), errs.ErrClientCallTimeout)
+
+	var buf bytes.Buffer
+	assert.NoError(t, serverMetrics.Write(&buf))
+	body := buf.String()
+	assert.Contains(t, body, `minirpc_server_calls_total{method="Bar.Double"} 1`)
+	// 客户端的截止时间会传递给服务端，两次超时服务端都可能记录
+	assert.GreaterOrEqual(t, serverMetrics.Counter("minirpc_server_timeouts_total", "", "method").With("Bar.Timeout").Value(), float64(1))
+	assert.Contains(t, body, `minirpc_server_connections 1`)
+	assert.Contains(t, body, `minirpc_server_call_duration_seconds_count{method="Bar.Double"} 1`)
+
+	buf.Reset()
+	assert.NoError(t, clientMetrics.Write(&buf))
+	body = buf.String()
+	assert.Contains(t, body, `minirpc_client_calls_total{method="Bar.Timeout"} 2`)
+	assert.Contains(t, body, `minirpc_client_timeouts_total{method="Bar.Timeout"} 2`)
+	assert.Contains(t, body, `minirpc_client_errors_total{method="Bar.Timeout",code="DeadlineExceeded"} 2`)
+	assert.Contains(t, body, `minirpc_client_in_flight{method="Bar.Double"} 0`)
+	assert.Greater(t, clientMetrics.Counter("minirpc_client_sent_bytes_total", "").With().Value(), float64(0))
+	assert.Greater(t, clientMetrics.Counter("minirpc_client_received_bytes_total", "").With().Value(), float64(0))
+
+	_ = client.Close()
+	assert.Equal(t, float64(0), connections.Value())
+}
+
+// Journal 记录单向调用收到的消息
+type Journal struct{ lines chan string }
+
+func (j *Journal) Append(line string, reply *int) error {
+	j.lines <- line
+	*reply = len(line)
+	return nil
+}
+
+func (j *Journal) Reject(line string, reply *int) error {
+	return errs.NewStatus(errs.CodeInvalidArgument, "rejected")
+}
+
+func TestClient_Notify(t *testing.T) {
+	t.Parallel()
+	registry := metrics.NewRegistry()
+	journal := &Journal{lines: make(chan string, 10)}
+	s := server.NewServer()
+	_ = s.Register(journal)
+	_ = s.Register(new(Bar))
+	l, _ := net.Listen("tcp", ":0")
+	go s.Accept(l, server.ServerOption{Metrics: registry})
+
+	client, err := Dial("tcp", l.Addr().String())
+	assert.NoError(t, err)
+	defer func() { _ = client.Close() }()
+
+	ctx := context.Background()
+	for _, line := range []string{"a", "b", "c"} {
+		assert.NoError(t, client.Notify(ctx, "Journal.Append", line))
+	}
+	// 方法失败或者不存在时服务端同样不回复
+	assert.NoError(t, client.Notify(ctx, "Journal.Reject", "d"))
+	assert.NoError(t, client.Notify(ctx, "Journal.Missing", "e"))
+	client.mu.Lock()
+	assert.Empty(t, client.pending)
+	client.mu.Unlock()
+
+	var got []string
+	for i := 0; i < 3; i++ {
+		select {
+		case line := <-journal.lines:
+			got = append(got, line)
+		case <-time.After(time.Second):
+			t.Fatal("one-way call was not executed")
+		}
+	}
+	assert.ElementsMatch(t, []string{"a", "b", "c"}, got)
+
+	// 之后的调用收到的是自己的响应
+	var reply int
+	assert.NoError(t, client.Call(ctx, "Bar.Double", 2, &reply))
+	assert.Equal(t, 4, reply)
+
+	oneWay := registry.Counter("minirpc_server_oneway_calls_total", "", "method")
+	assert.Eventually(t, func() bool {
+		return oneWay.With("Journal.Reject").Value() == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, float64(3), oneWay.With("Journal.Append").Value())
+	assert.Equal(t, float64(0), oneWay.With("Bar.Double").Value())
+
+	_ = client.Close()
+	assert.ErrorIs(t, client.Notify(ctx, "Journal.Append", "f"), errs.ErrShutdown)
+}
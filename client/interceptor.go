@@ -0,0 +1,29 @@
+package client
+
+import "context"
+
+// UnaryInvoker 完成一次调用，由拦截器链的最内层执行
+type UnaryInvoker func(ctx context.Context, serviceMethod string, args, reply interface{}) error
+
+// UnaryClientInterceptor 拦截一次调用。
+// 拦截器通过 ctx 读写元数据（metadata.FromOutgoingContext / metadata.WithTrailer），
+// 调用 invoker 继续执行后续的拦截器与实际请求，并可以检查或替换返回的错误
+type UnaryClientInterceptor func(ctx context.Context, serviceMethod string, args, reply interface{}, invoker UnaryInvoker) error
+
+// ChainUnaryClient 将多个拦截器组合成一个，先传入的拦截器位于外层、先执行
+func ChainUnaryClient(interceptors ...UnaryClientInterceptor) UnaryClientInterceptor {
+	return func(ctx context.Context, serviceMethod string, args, reply interface{}, invoker UnaryInvoker) error {
+		return ChainInvoker(interceptors, invoker)(ctx, serviceMethod, args, reply)
+	}
+}
+
+// ChainInvoker 用拦截器依次包装 invoker，返回的 invoker 从第一个拦截器开始执行
+func ChainInvoker(interceptors []UnaryClientInterceptor, invoker UnaryInvoker) UnaryInvoker {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoker
+		invoker = func(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+			return interceptor(ctx, serviceMethod, args, reply, next)
+		}
+	}
+	return invoker
+}
@@ -0,0 +1,58 @@
+package client
+
+import (
+	"log"
+	"time"
+
+	"github.com/qiancijun/minirpc/codec"
+)
+
+// keepalive 在连接空闲 interval 后发送 ping，发送后 timeout 内没有收到任何消息就认为连接已经失效，
+// 关闭连接使接收循环退出，处理中的调用以 errs.ErrKeepaliveTimeout 失败
+func (c *Client) keepalive(interval, timeout time.Duration) {
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-c.disconnected:
+			return
+		}
+		if idle := time.Since(time.Unix(0, c.lastRead.Load())); idle < interval {
+			timer.Reset(interval - idle)
+			continue
+		}
+		sent := time.Now().UnixNano()
+		// 半开的连接上写入可能阻塞，不能耽误超时检查
+		go c.sendPing()
+		timer.Reset(timeout)
+		select {
+		case <-timer.C:
+		case <-c.disconnected:
+			return
+		}
+		if c.lastRead.Load() < sent {
+			log.Println("rpc client: keepalive timeout, closing connection")
+			c.expired.Store(true)
+			_ = c.cc.Close()
+			return
+		}
+		timer.Reset(interval)
+	}
+}
+
+func (c *Client) sendPing() {
+	c.sending.Lock()
+	defer c.sending.Unlock()
+	if err := c.cc.Write(&codec.Header{Type: codec.MessagePing}, struct{}{}); err != nil {
+		log.Println("rpc client: send ping error: ", err)
+	}
+}
+
+func (c *Client) sendPong(seq uint64) {
+	c.sending.Lock()
+	defer c.sending.Unlock()
+	if err := c.cc.Write(&codec.Header{Seq: seq, Type: codec.MessagePong}, struct{}{}); err != nil {
+		log.Println("rpc client: send pong error: ", err)
+	}
+}
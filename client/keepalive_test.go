@@ -0,0 +1,94 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/qiancijun/minirpc/common"
+	"github.com/qiancijun/minirpc/errs"
+	"github.com/qiancijun/minirpc/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startSilentServer 完成握手后不再发送任何消息，模拟半开的连接
+func startSilentServer(t *testing.T, capabilities ...string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				var opt common.Option
+				dec := json.NewDecoder(conn)
+				if dec.Decode(&opt) != nil {
+					return
+				}
+				resp := &common.HandshakeResponse{CodecType: opt.CodecType, Version: opt.Version, Capabilities: capabilities}
+				if json.NewEncoder(conn).Encode(resp) != nil {
+					return
+				}
+				_, _ = io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestClient_KeepaliveTimeout(t *testing.T) {
+	t.Parallel()
+	opt := &common.Option{KeepaliveInterval: 20 * time.Millisecond, KeepaliveTimeout: 50 * time.Millisecond}
+
+	t.Run("timeout", func(t *testing.T) {
+		addr := startSilentServer(t, common.CapabilityFrame, common.CapabilityKeepalive)
+		client, err := Dial("tcp", addr, opt)
+		require.NoError(t, err)
+		defer func() { _ = client.Close() }()
+
+		start := time.Now()
+		err = client.Call(context.Background(), "Bar.Double", 1, new(int))
+		assert.ErrorIs(t, err, errs.ErrKeepaliveTimeout)
+		assert.Equal(t, errs.CodeUnavailable, errs.CodeOf(err))
+		assert.Less(t, time.Since(start), 500*time.Millisecond)
+		assert.False(t, client.IsAvailable())
+	})
+
+	t.Run("server without keepalive", func(t *testing.T) {
+		addr := startSilentServer(t, common.CapabilityFrame)
+		client, err := Dial("tcp", addr, opt)
+		require.NoError(t, err)
+		defer func() { _ = client.Close() }()
+
+		time.Sleep(150 * time.Millisecond)
+		assert.True(t, client.IsAvailable(), "client must not ping servers that cannot answer")
+	})
+}
+
+func TestClient_Keepalive(t *testing.T) {
+	t.Parallel()
+	s := server.NewServer()
+	require.NoError(t, s.Register(new(Bar)))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	// 两端都发送心跳
+	go s.Accept(l, server.ServerOption{KeepaliveInterval: 20 * time.Millisecond, KeepaliveTimeout: 50 * time.Millisecond})
+
+	client, err := Dial("tcp", l.Addr().String(), &common.Option{KeepaliveInterval: 20 * time.Millisecond, KeepaliveTimeout: 50 * time.Millisecond})
+	require.NoError(t, err)
+	defer func() { _ = client.Close() }()
+
+	time.Sleep(200 * time.Millisecond)
+	assert.True(t, client.IsAvailable())
+	var reply int
+	require.NoError(t, client.Call(context.Background(), "Bar.Double", 4, &reply))
+	assert.Equal(t, 8, reply)
+}
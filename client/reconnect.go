@@ -0,0 +1,315 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/qiancijun/minirpc/common"
+	"github.com/qiancijun/minirpc/errs"
+)
+
+// ConnState 是 ReconnectingClient 的连接状态
+type ConnState int
+
+const (
+	StateConnecting       ConnState = iota // 正在建立连接并握手
+	StateReady                             // 连接可用
+	StateTransientFailure                  // 连接失败或断开，等待退避时间后重连
+	StateShutdown                          // 已经关闭，不再重连
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateConnecting:
+		return "Connecting"
+	case StateReady:
+		return "Ready"
+	case StateTransientFailure:
+		return "TransientFailure"
+	case StateShutdown:
+		return "Shutdown"
+	}
+	return "Unknown"
+}
+
+// DisconnectPolicy 决定连接不可用期间新调用的处理方式
+type DisconnectPolicy int
+
+const (
+	// QueueWhileDisconnected 让调用等待重连成功，直到 ctx 结束
+	QueueWhileDisconnected DisconnectPolicy = iota
+	// FailFast 让调用立即返回 errs.ErrClientDisconnected
+	FailFast
+)
+
+// ReconnectOption 配置重连的退避策略，零值字段使用 DefaultReconnectOption 中的值
+type ReconnectOption struct {
+	InitialBackoff time.Duration // 第一次重连前的等待时间
+	MaxBackoff     time.Duration // 等待时间的上限
+	Multiplier     float64       // 每次失败后等待时间的倍数
+	Jitter         float64       // 等待时间的随机浮动比例，0.2 表示上下浮动 20%
+	Policy         DisconnectPolicy
+	// OnStateChange 在状态变化时被依次同步调用，不能阻塞，也不能调用 Close
+	OnStateChange func(state ConnState)
+}
+
+var DefaultReconnectOption = ReconnectOption{
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     10 * time.Second,
+	Multiplier:     1.6,
+	Jitter:         0.2,
+}
+
+// backoff 返回第 attempt 次连续失败后的等待时间，attempt 从 0 开始
+func (o *ReconnectOption) backoff(attempt int) time.Duration {
+	return expBackoff(o.InitialBackoff, o.MaxBackoff, o.Multiplier, o.Jitter, attempt)
+}
+
+// expBackoff 返回指数增长、带有随机浮动的等待时间，attempt 从 0 开始
+func expBackoff(initial, maxBackoff time.Duration, multiplier, jitter float64, attempt int) time.Duration {
+	d := float64(initial) * math.Pow(multiplier, float64(attempt))
+	d = math.Min(d, float64(maxBackoff))
+	d *= 1 + jitter*(rand.Float64()*2-1)
+	return time.Duration(d)
+}
+
+// ReconnectingClient 在连接断开后自动重新拨号并握手，对调用方表现为一个一直可用的客户端。
+// 断开时已经发出的调用与流以连接错误结束，是否重试由调用方决定
+type ReconnectingClient struct {
+	dial func() (*Client, error)
+	opt  ReconnectOption
+
+	notifyMu     sync.Mutex // 保证状态变化按顺序通知
+	mu           sync.Mutex
+	state        ConnState
+	cur          *Client
+	ready        chan struct{} // 进入 StateReady 或 StateShutdown 时关闭
+	interceptors []UnaryClientInterceptor
+	stop         chan struct{}
+}
+
+// NewReconnectingClient 立即返回，并在后台通过 dial 建立连接，连接断开后按退避策略重新调用 dial
+func NewReconnectingClient(dial func() (*Client, error), opt *ReconnectOption) *ReconnectingClient {
+	o := DefaultReconnectOption
+	if opt != nil {
+		o.Policy = opt.Policy
+		o.OnStateChange = opt.OnStateChange
+		if opt.InitialBackoff > 0 {
+			o.InitialBackoff = opt.InitialBackoff
+		}
+		if opt.MaxBackoff > 0 {
+			o.MaxBackoff = opt.MaxBackoff
+		}
+		if opt.Multiplier >= 1 {
+			o.Multiplier = opt.Multiplier
+		}
+		if opt.Jitter > 0 {
+			o.Jitter = math.Min(opt.Jitter, 1)
+		}
+	}
+	rc := &ReconnectingClient{
+		dial:  dial,
+		opt:   o,
+		state: StateConnecting,
+		ready: make(chan struct{}),
+		stop:  make(chan struct{}),
+	}
+	go rc.run()
+	return rc
+}
+
+// DialReconnecting 以 XDial 的地址格式创建自动重连的客户端，每次重连都会重新进行 Option 握手
+func DialReconnecting(rpcAddr string, ropt *ReconnectOption, opts ...*common.Option) *ReconnectingClient {
+	return NewReconnectingClient(func() (*Client, error) {
+		return XDial(rpcAddr, opts...)
+	}, ropt)
+}
+
+// run 循环建立连接，并在连接断开后重连，直到 Close。
+// 连接断开后同样先等待一次退避时间，避免服务端接受连接后立刻断开时反复重连
+func (rc *ReconnectingClient) run() {
+	attempt := 0
+	for {
+		c, err := rc.dial()
+		if err == nil {
+			attempt = 0
+			if !rc.setState(StateReady, c) {
+				_ = c.Close()
+				return
+			}
+			select {
+			case <-c.disconnected:
+				log.Println("rpc client: connection lost, reconnecting")
+			case <-rc.stop:
+				return
+			}
+		} else {
+			log.Println("rpc client: reconnect error: ", err)
+		}
+		if !rc.setState(StateTransientFailure, nil) {
+			return
+		}
+		select {
+		case <-time.After(rc.opt.backoff(attempt)):
+		case <-rc.stop:
+			return
+		}
+		attempt++
+		if !rc.setState(StateConnecting, nil) {
+			return
+		}
+	}
+}
+
+// setState 切换状态并通知回调，已经关闭时返回 false
+func (rc *ReconnectingClient) setState(state ConnState, c *Client) bool {
+	rc.notifyMu.Lock()
+	defer rc.notifyMu.Unlock()
+	rc.mu.Lock()
+	if rc.state == StateShutdown {
+		rc.mu.Unlock()
+		return false
+	}
+	if state == StateReady {
+		c.Use(rc.interceptors...)
+		rc.cur = c
+		close(rc.ready)
+	} else if rc.state == StateReady {
+		rc.cur = nil
+		rc.ready = make(chan struct{})
+	}
+	rc.state = state
+	rc.mu.Unlock()
+	if rc.opt.OnStateChange != nil {
+		rc.opt.OnStateChange(state)
+	}
+	return true
+}
+
+// State 返回当前的连接状态
+func (rc *ReconnectingClient) State() ConnState {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.state
+}
+
+// Use 为之后建立的每个连接追加拦截器，需要在发起调用前设置
+func (rc *ReconnectingClient) Use(interceptors ...UnaryClientInterceptor) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.interceptors = append(rc.interceptors, interceptors...)
+	if rc.cur != nil {
+		rc.cur.Use(interceptors...)
+	}
+}
+
+// client 返回当前可用的连接，按照 DisconnectPolicy 等待重连或者立即失败
+func (rc *ReconnectingClient) client(ctx context.Context) (*Client, error) {
+	for {
+		rc.mu.Lock()
+		state, cur, ready := rc.state, rc.cur, rc.ready
+		rc.mu.Unlock()
+
+		var wait <-chan struct{} = ready
+		switch {
+		case state == StateShutdown:
+			return nil, errs.ErrShutdown
+		case state == StateReady && cur.AcceptingCalls():
+			return cur, nil
+		case rc.opt.Policy == FailFast:
+			return nil, errs.ErrClientDisconnected
+		case state == StateReady:
+			// 连接已经断开或收到了 go away，等待重连 goroutine 切换状态
+			wait = cur.disconnected
+		}
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil, errs.ErrClientCallTimeout
+			}
+			return nil, errs.ErrClientCallCanceled
+		}
+	}
+}
+
+// Call 在当前连接上发起调用。QueueWhileDisconnected 策略下，
+// 因为连接不可用而没有发出的调用会在重连后再发送
+func (rc *ReconnectingClient) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	for {
+		c, err := rc.client(ctx)
+		if err != nil {
+			return err
+		}
+		err = c.Call(ctx, serviceMethod, args, reply)
+		if !rc.requeue(err) {
+			return err
+		}
+	}
+}
+
+// Notify 在当前连接上发起单向调用，连接不可用时的处理方式与 Call 相同
+func (rc *ReconnectingClient) Notify(ctx context.Context, serviceMethod string, args interface{}) error {
+	for {
+		c, err := rc.client(ctx)
+		if err != nil {
+			return err
+		}
+		err = c.Notify(ctx, serviceMethod, args)
+		if !rc.requeue(err) {
+			return err
+		}
+	}
+}
+
+// NewStream 在当前连接上发起流式调用，流不会跨越重连，连接断开时流以连接错误结束
+func (rc *ReconnectingClient) NewStream(ctx context.Context, serviceMethod string, args, elem interface{}) (*Stream, error) {
+	for {
+		c, err := rc.client(ctx)
+		if err != nil {
+			return nil, err
+		}
+		s, err := c.NewStream(ctx, serviceMethod, args, elem)
+		if !rc.requeue(err) {
+			return s, err
+		}
+	}
+}
+
+// requeue 判断调用是否因为连接不可用而根本没有发出，此时可以安全地在新连接上重新发送
+func (rc *ReconnectingClient) requeue(err error) bool {
+	return rc.opt.Policy == QueueWhileDisconnected && errors.Is(err, errs.ErrShutdown)
+}
+
+// Close 停止重连并关闭当前连接，等待中的调用返回 errs.ErrShutdown
+func (rc *ReconnectingClient) Close() error {
+	rc.notifyMu.Lock()
+	defer rc.notifyMu.Unlock()
+	rc.mu.Lock()
+	if rc.state == StateShutdown {
+		rc.mu.Unlock()
+		return errs.ErrShutdown
+	}
+	cur := rc.cur
+	rc.state = StateShutdown
+	rc.cur = nil
+	close(rc.stop)
+	select {
+	case <-rc.ready:
+	default:
+		close(rc.ready)
+	}
+	rc.mu.Unlock()
+	if rc.opt.OnStateChange != nil {
+		rc.opt.OnStateChange(StateShutdown)
+	}
+	if cur != nil {
+		return cur.Close()
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qiancijun/minirpc/errs"
+	"github.com/qiancijun/minirpc/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconnectOption_backoff(t *testing.T) {
+	opt := ReconnectOption{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2, Jitter: 0.2}
+	for attempt, want := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		want *= time.Millisecond
+		for i := 0; i < 20; i++ {
+			d := opt.backoff(attempt)
+			assert.GreaterOrEqual(t, d, want*8/10)
+			assert.LessOrEqual(t, d, want*12/10)
+		}
+	}
+
+	opt.Jitter = 0
+	assert.Equal(t, 400*time.Millisecond, opt.backoff(2))
+}
+
+// dropListener 记录接受的连接，测试通过 drop 模拟网络断开
+type dropListener struct {
+	net.Listener
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func (l *dropListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.mu.Lock()
+		l.conns = append(l.conns, conn)
+		l.mu.Unlock()
+	}
+	return conn, err
+}
+
+func (l *dropListener) drop() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, conn := range l.conns {
+		_ = conn.Close()
+	}
+	l.conns = nil
+}
+
+// startReconnectServer 返回可以断开连接的监听器，以及在 down 为 true 时拒绝连接的拨号函数
+func startReconnectServer(t *testing.T) (*dropListener, *atomic.Bool, func() (*Client, error)) {
+	s := server.NewServer()
+	require.NoError(t, s.Register(new(Bar)))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	dl := &dropListener{Listener: l}
+	go s.Accept(dl, server.DefaultServerOption)
+
+	down := new(atomic.Bool)
+	dial := func() (*Client, error) {
+		if down.Load() {
+			return nil, errors.New("connection refused")
+		}
+		return Dial("tcp", l.Addr().String())
+	}
+	return dl, down, dial
+}
+
+// stateRecorder 通过 channel 转发状态变化，回调不能阻塞，channel 满了就丢弃
+func stateRecorder() (chan ConnState, func(ConnState)) {
+	states := make(chan ConnState, 100)
+	return states, func(s ConnState) {
+		select {
+		case states <- s:
+		default:
+		}
+	}
+}
+
+func waitState(t *testing.T, states chan ConnState, want ConnState) {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case s := <-states:
+			if s == want {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("state %s was not reached", want)
+		}
+	}
+}
+
+func TestReconnectingClient(t *testing.T) {
+	t.Parallel()
+	l, _, dial := startReconnectServer(t)
+	states, onChange := stateRecorder()
+	rc := NewReconnectingClient(dial, &ReconnectOption{InitialBackoff: 10 * time.Millisecond, OnStateChange: onChange})
+	ctx := context.Background()
+
+	// 第一次连接建立前的调用会等待
+	var reply int
+	require.NoError(t, rc.Call(ctx, "Bar.Double", 2, &reply))
+	assert.Equal(t, 4, reply)
+	assert.Equal(t, StateReady, rc.State())
+	assert.Equal(t, StateReady, <-states)
+
+	l.drop()
+	assert.Equal(t, StateTransientFailure, <-states)
+	assert.Equal(t, StateConnecting, <-states)
+	assert.Equal(t, StateReady, <-states)
+	require.NoError(t, rc.Call(ctx, "Bar.Double", 3, &reply))
+	assert.Equal(t, 6, reply)
+
+	require.NoError(t, rc.Close())
+	assert.Equal(t, StateShutdown, <-states)
+	assert.Equal(t, StateShutdown, rc.State())
+	assert.ErrorIs(t, rc.Call(ctx, "Bar.Double", 3, &reply), errs.ErrShutdown)
+	assert.ErrorIs(t, rc.Close(), errs.ErrShutdown)
+}
+
+func TestReconnectingClient_Policy(t *testing.T) {
+	t.Parallel()
+
+	t.Run("queue", func(t *testing.T) {
+		l, down, dial := startReconnectServer(t)
+		states, onChange := stateRecorder()
+		rc := NewReconnectingClient(dial, &ReconnectOption{InitialBackoff: 10 * time.Millisecond, MaxBackoff: 20 * time.Millisecond, OnStateChange: onChange})
+		defer func() { _ = rc.Close() }()
+		waitState(t, states, StateReady)
+
+		down.Store(true)
+		l.drop()
+		waitState(t, states, StateTransientFailure)
+
+		// 断开期间的调用等到重连成功后发出
+		done := make(chan error, 1)
+		var reply int
+		go func() { done <- rc.Call(context.Background(), "Bar.Double", 5, &reply) }()
+		select {
+		case err := <-done:
+			t.Fatalf("call returned while disconnected: %v", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+		down.Store(false)
+		select {
+		case err := <-done:
+			require.NoError(t, err)
+			assert.Equal(t, 10, reply)
+		case <-time.After(2 * time.Second):
+			t.Fatal("queued call was not sent after reconnecting")
+		}
+
+		// 等待受 ctx 限制
+		waitState(t, states, StateReady)
+		down.Store(true)
+		l.drop()
+		waitState(t, states, StateTransientFailure)
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, rc.Call(ctx, "Bar.Double", 1, &reply), errs.ErrClientCallTimeout)
+	})
+
+	t.Run("fail fast", func(t *testing.T) {
+		l, down, dial := startReconnectServer(t)
+		states, onChange := stateRecorder()
+		rc := NewReconnectingClient(dial, &ReconnectOption{InitialBackoff: 10 * time.Millisecond, Policy: FailFast, OnStateChange: onChange})
+		defer func() { _ = rc.Close() }()
+		waitState(t, states, StateReady)
+
+		down.Store(true)
+		l.drop()
+		waitState(t, states, StateTransientFailure)
+		err := rc.Call(context.Background(), "Bar.Double", 1, new(int))
+		assert.ErrorIs(t, err, errs.ErrClientDisconnected)
+		assert.Equal(t, errs.CodeUnavailable, errs.CodeOf(err))
+
+		down.Store(false)
+		waitState(t, states, StateReady)
+		assert.NoError(t, rc.Call(context.Background(), "Bar.Double", 1, new(int)))
+	})
+}
@@ -0,0 +1,212 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qiancijun/minirpc/errs"
+	"github.com/qiancijun/minirpc/metadata"
+	"github.com/qiancijun/minirpc/metrics"
+	"github.com/qiancijun/minirpc/trace"
+)
+
+// RetryAttemptKey 是请求元数据中记录当前是第几次尝试的键，只在重试时设置，第一次重试为 "2"
+const RetryAttemptKey = "minirpc-attempt"
+
+// RetryPolicy 声明一个方法的重试策略，只有标记为 Idempotent 的方法才会重试。
+// 零值的退避字段使用 DefaultRetryPolicy 中的值，Jitter 小于 0 表示不浮动
+type RetryPolicy struct {
+	MaxAttempts    int           // 包括第一次调用在内的最大尝试次数，小于 2 时不重试
+	InitialBackoff time.Duration // 第一次重试前的等待时间
+	MaxBackoff     time.Duration // 等待时间的上限
+	Multiplier     float64       // 每次重试后等待时间的倍数
+	Jitter         float64       // 等待时间的随机浮动比例，0.2 表示上下浮动 20%
+	// RetryableCodes 是可以重试的错误码，为空时使用 DefaultRetryPolicy.RetryableCodes。
+	// 连接错误（见 IsConnectionError）总是可以重试
+	RetryableCodes []errs.Code
+	// Idempotent 表示方法重复执行不会产生额外的影响，请求可能已经执行过时也能安全地重试
+	Idempotent bool
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	InitialBackoff: 50 * time.Millisecond,
+	MaxBackoff:     time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+	RetryableCodes: []errs.Code{errs.CodeUnavailable},
+}
+
+// RetryConfig 按方法声明重试策略
+type RetryConfig struct {
+	// Policies 以 "Service.Method" 或 "Service" 为键，方法的策略优先于所在服务的策略
+	Policies map[string]*RetryPolicy
+	// Throttle 是所有方法共享的重试预算，为 nil 时不限制
+	Throttle *RetryThrottle
+	// Metrics 记录每次调用的尝试次数与被限制的重试，为 nil 时使用 metrics.DefaultRegistry
+	Metrics *metrics.Registry
+}
+
+// RetryThrottle 是共享的重试预算，避免服务端过载时重试成倍放大流量：
+// 每次可重试的失败消耗一个令牌，每次成功归还 ratio 个令牌，令牌不超过上限的一半时停止重试
+type RetryThrottle struct {
+	mu        sync.Mutex
+	maxTokens float64
+	ratio     float64
+	tokens    float64
+}
+
+// NewRetryThrottle 创建令牌上限为 maxTokens 的重试预算，初始时令牌是满的
+func NewRetryThrottle(maxTokens, ratio float64) *RetryThrottle {
+	return &RetryThrottle{maxTokens: maxTokens, ratio: ratio, tokens: maxTokens}
+}
+
+func (t *RetryThrottle) onSuccess() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tokens = math.Min(t.tokens+t.ratio, t.maxTokens)
+}
+
+// onFailure 消耗一个令牌，并返回是否还允许重试
+func (t *RetryThrottle) onFailure() bool {
+	if t == nil {
+		return true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tokens = math.Max(t.tokens-1, 0)
+	return t.tokens > t.maxTokens/2
+}
+
+// retryPolicyKey 标记由 RetryInterceptor 按照策略重试的调用
+type retryPolicyKey struct{}
+
+// RetriedByPolicy 判断 ctx 中的调用是否由 RetryInterceptor 按照策略重试，
+// XClient 据此不再叠加 fail mode 的重试，避免尝试次数成倍增加
+func RetriedByPolicy(ctx context.Context) bool {
+	return ctx.Value(retryPolicyKey{}) != nil
+}
+
+// RetryInterceptor 返回按照 cfg 重试失败调用的拦截器。
+// 重试只在 ctx 结束前进行，剩余时间不够等待退避时间时直接返回最后一次的错误；
+// 用于 XClient 时每次重试都会按照 fail mode 重新选择服务端，fail mode 自己不再重试。
+// ReconnectingClient.Use 的拦截器作用于单个连接，
+// 需要在重连后重试时，直接以 ReconnectingClient.Call 作为 invoker 调用返回的拦截器
+func RetryInterceptor(cfg RetryConfig) UnaryClientInterceptor {
+	policies := make(map[string]*RetryPolicy, len(cfg.Policies))
+	for key, p := range cfg.Policies {
+		policies[key] = p.withDefaults()
+	}
+	m := metrics.NewRPC(cfg.Metrics, "client")
+	return func(ctx context.Context, serviceMethod string, args, reply interface{}, invoker UnaryInvoker) error {
+		p := lookupPolicy(policies, serviceMethod)
+		if p == nil {
+			return invoker(ctx, serviceMethod, args, reply)
+		}
+		ctx = context.WithValue(ctx, retryPolicyKey{}, true)
+		attempt := 1
+		defer func() { m.Attempts(serviceMethod, attempt) }()
+		err := invoker(ctx, serviceMethod, args, reply)
+		for ; ; attempt++ {
+			if err == nil {
+				cfg.Throttle.onSuccess()
+				return nil
+			}
+			if !p.retryable(err) {
+				return err
+			}
+			if !cfg.Throttle.onFailure() {
+				m.RetryThrottled(serviceMethod)
+				return err
+			}
+			if attempt >= p.MaxAttempts {
+				return err
+			}
+			wait := expBackoff(p.InitialBackoff, p.MaxBackoff, p.Multiplier, p.Jitter, attempt-1)
+			if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+				return err
+			}
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return err
+			}
+			trace.SpanFromContext(ctx).AddEvent("client.retry",
+				"attempt", strconv.Itoa(attempt+1), "code", errs.CodeOf(err).String())
+			actx := metadata.AppendToOutgoingContext(ctx, RetryAttemptKey, strconv.Itoa(attempt+1))
+			err = invoker(actx, serviceMethod, args, reply)
+		}
+	}
+}
+
+// Idempotent 判断 serviceMethod 的策略是否声明了幂等，XClient 的 fail mode 与 RetryInterceptor
+// 共用这份声明，决定请求可能已经执行过时能否重试
+func (cfg RetryConfig) Idempotent(serviceMethod string) bool {
+	p := findPolicy(cfg.Policies, serviceMethod)
+	return p != nil && p.Idempotent
+}
+
+// lookupPolicy 返回方法的重试策略，只有幂等的方法才会返回
+func lookupPolicy(policies map[string]*RetryPolicy, serviceMethod string) *RetryPolicy {
+	p := findPolicy(policies, serviceMethod)
+	if p == nil || !p.Idempotent || p.MaxAttempts < 2 {
+		return nil
+	}
+	return p
+}
+
+// findPolicy 返回方法的策略，方法没有策略时返回所在服务的策略
+func findPolicy(policies map[string]*RetryPolicy, serviceMethod string) *RetryPolicy {
+	if p, ok := policies[serviceMethod]; ok {
+		return p
+	}
+	service, _, _ := strings.Cut(serviceMethod, ".")
+	return policies[service]
+}
+
+func (p *RetryPolicy) withDefaults() *RetryPolicy {
+	if p == nil {
+		return nil
+	}
+	o := *p
+	if o.InitialBackoff <= 0 {
+		o.InitialBackoff = DefaultRetryPolicy.InitialBackoff
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = max(DefaultRetryPolicy.MaxBackoff, o.InitialBackoff)
+	}
+	if o.Multiplier < 1 {
+		o.Multiplier = DefaultRetryPolicy.Multiplier
+	}
+	switch {
+	case o.Jitter == 0:
+		o.Jitter = DefaultRetryPolicy.Jitter
+	case o.Jitter < 0:
+		o.Jitter = 0
+	}
+	o.Jitter = math.Min(o.Jitter, 1)
+	if len(o.RetryableCodes) == 0 {
+		o.RetryableCodes = DefaultRetryPolicy.RetryableCodes
+	}
+	return &o
+}
+
+// retryable 判断错误是否可以重试，调用方的 ctx 结束导致的错误不会重试
+func (p *RetryPolicy) retryable(err error) bool {
+	if IsConnectionError(err) {
+		return true
+	}
+	code := errs.CodeOf(err)
+	if code == errs.CodeCanceled || errors.Is(err, errs.ErrClientCallTimeout) {
+		return false
+	}
+	return slices.Contains(p.RetryableCodes, code)
+}
@@ -0,0 +1,204 @@
+package client
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qiancijun/minirpc/errs"
+	"github.com/qiancijun/minirpc/metadata"
+	"github.com/qiancijun/minirpc/metrics"
+	"github.com/qiancijun/minirpc/server"
+	"github.com/qiancijun/minirpc/trace"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Flaky 在前 fails 次调用时返回 code，并记录每次调用携带的尝试次数
+type Flaky struct {
+	mu       sync.Mutex
+	fails    int
+	code     errs.Code
+	attempts []string
+}
+
+func (f *Flaky) record(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.attempts = append(f.attempts, md.Get(RetryAttemptKey))
+	if f.fails > 0 {
+		f.fails--
+		return errs.NewStatus(f.code, "flaky")
+	}
+	return nil
+}
+
+func (f *Flaky) Get(ctx context.Context, n int, reply *int) error {
+	*reply = n
+	return f.record(ctx)
+}
+
+func (f *Flaky) Put(ctx context.Context, n int, reply *int) error {
+	return f.record(ctx)
+}
+
+func (f *Flaky) reset(fails int, code errs.Code) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fails, f.code, f.attempts = fails, code, nil
+}
+
+func (f *Flaky) calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.attempts
+}
+
+func startFlakyServer(t *testing.T) (*Flaky, *Client) {
+	f := new(Flaky)
+	s := server.NewServer()
+	require.NoError(t, s.Register(f))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go s.Accept(l, server.DefaultServerOption)
+	c, err := Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = c.Close() })
+	return f, c
+}
+
+func TestRetryInterceptor(t *testing.T) {
+	t.Parallel()
+	f, c := startFlakyServer(t)
+	reg := metrics.NewRegistry()
+	c.Use(RetryInterceptor(RetryConfig{
+		Policies: map[string]*RetryPolicy{
+			"Flaky":     {MaxAttempts: 3, InitialBackoff: time.Millisecond, Idempotent: true},
+			"Flaky.Put": {MaxAttempts: 3, InitialBackoff: time.Millisecond},
+		},
+		Metrics: reg,
+	}))
+	attempts := reg.Histogram("minirpc_client_call_attempts", "", nil, "method").With("Flaky.Get")
+
+	t.Run("retries idempotent methods", func(t *testing.T) {
+		f.reset(2, errs.CodeUnavailable)
+		var reply int
+		require.NoError(t, c.Call(context.Background(), "Flaky.Get", 7, &reply))
+		assert.Equal(t, 7, reply)
+		// 重试的请求通过元数据告知服务端当前的尝试次数
+		assert.Equal(t, []string{"", "2", "3"}, f.calls())
+		assert.Equal(t, uint64(1), attempts.Count())
+		assert.Equal(t, float64(3), attempts.Sum())
+	})
+
+	t.Run("gives up after max attempts", func(t *testing.T) {
+		f.reset(5, errs.CodeUnavailable)
+		err := c.Call(context.Background(), "Flaky.Get", 1, new(int))
+		assert.Equal(t, errs.CodeUnavailable, errs.CodeOf(err))
+		assert.Len(t, f.calls(), 3)
+	})
+
+	t.Run("skips non-retryable codes", func(t *testing.T) {
+		f.reset(1, errs.CodeInvalidArgument)
+		assert.Error(t, c.Call(context.Background(), "Flaky.Get", 1, new(int)))
+		assert.Len(t, f.calls(), 1)
+	})
+
+	t.Run("skips methods not marked idempotent", func(t *testing.T) {
+		f.reset(1, errs.CodeUnavailable)
+		assert.Error(t, c.Call(context.Background(), "Flaky.Put", 1, new(int)))
+		assert.Len(t, f.calls(), 1)
+	})
+
+	t.Run("respects the deadline", func(t *testing.T) {
+		// 使用没有拦截器的客户端，只经过这里的一层重试
+		f, c := startFlakyServer(t)
+		f.reset(5, errs.CodeUnavailable)
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		interceptor := RetryInterceptor(RetryConfig{
+			Policies: map[string]*RetryPolicy{"Flaky": {MaxAttempts: 5, InitialBackoff: time.Second, Idempotent: true}},
+			Metrics:  reg,
+		})
+		start := time.Now()
+		err := interceptor(ctx, "Flaky.Get", 1, new(int), c.Call)
+		assert.Equal(t, errs.CodeUnavailable, errs.CodeOf(err))
+		assert.Less(t, time.Since(start), 500*time.Millisecond)
+		assert.Len(t, f.calls(), 1)
+	})
+}
+
+func TestRetryPolicy_withDefaults(t *testing.T) {
+	p := (&RetryPolicy{MaxAttempts: 3}).withDefaults()
+	assert.Equal(t, DefaultRetryPolicy.InitialBackoff, p.InitialBackoff)
+	assert.Equal(t, DefaultRetryPolicy.RetryableCodes, p.RetryableCodes)
+	// Jitter 为 0 时使用默认值，小于 0 时不浮动
+	assert.Equal(t, DefaultRetryPolicy.Jitter, p.Jitter)
+	assert.Equal(t, float64(0), (&RetryPolicy{Jitter: -1}).withDefaults().Jitter)
+	assert.Equal(t, float64(1), (&RetryPolicy{Jitter: 2}).withDefaults().Jitter)
+}
+
+func TestRetryInterceptor_Throttle(t *testing.T) {
+	t.Parallel()
+	f, c := startFlakyServer(t)
+	reg := metrics.NewRegistry()
+	c.Use(RetryInterceptor(RetryConfig{
+		Policies: map[string]*RetryPolicy{"Flaky.Get": {MaxAttempts: 5, InitialBackoff: time.Millisecond, Idempotent: true}},
+		Throttle: NewRetryThrottle(4, 1),
+		Metrics:  reg,
+	}))
+	throttled := reg.Counter("minirpc_client_retries_throttled_total", "", "method").With("Flaky.Get")
+
+	// 4 个令牌，两次失败后令牌降到上限的一半，停止重试
+	f.reset(10, errs.CodeUnavailable)
+	assert.Error(t, c.Call(context.Background(), "Flaky.Get", 1, new(int)))
+	assert.Len(t, f.calls(), 2)
+	assert.Equal(t, float64(1), throttled.Value())
+
+	// 预算不足时同样不会重试，成功的调用逐渐恢复预算
+	f.reset(1, errs.CodeUnavailable)
+	assert.Error(t, c.Call(context.Background(), "Flaky.Get", 1, new(int)))
+	assert.Len(t, f.calls(), 1)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, c.Call(context.Background(), "Flaky.Get", 1, new(int)))
+	}
+	f.reset(1, errs.CodeUnavailable)
+	require.NoError(t, c.Call(context.Background(), "Flaky.Get", 1, new(int)))
+	assert.Len(t, f.calls(), 2)
+}
+
+func TestRetryInterceptor_Connection(t *testing.T) {
+	t.Parallel()
+	exporter := &trace.InMemoryExporter{}
+	tracer := trace.NewTracer(exporter)
+	l, down, dial := startReconnectServer(t)
+	rc := NewReconnectingClient(dial, &ReconnectOption{InitialBackoff: 10 * time.Millisecond, Policy: FailFast})
+	defer func() { _ = rc.Close() }()
+	// ReconnectingClient.Use 作用于每个连接，重试需要包在 rc.Call 外层才能换到新的连接上
+	retry := RetryInterceptor(RetryConfig{
+		Policies: map[string]*RetryPolicy{"Bar.Double": {MaxAttempts: 10, InitialBackoff: 20 * time.Millisecond, Idempotent: true}},
+		Metrics:  metrics.NewRegistry(),
+	})
+
+	// 连接断开时的调用在重连后重试成功
+	ctx, span := tracer.Start(context.Background(), "test", trace.KindInternal)
+	require.Eventually(t, func() bool { return rc.State() == StateReady }, time.Second, 5*time.Millisecond)
+	down.Store(true)
+	l.drop()
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		down.Store(false)
+	}()
+	var reply int
+	require.NoError(t, retry(ctx, "Bar.Double", 4, &reply, rc.Call))
+	assert.Equal(t, 8, reply)
+	span.Finish(nil)
+
+	events := span.Events()
+	require.NotEmpty(t, events)
+	assert.Equal(t, "client.retry", events[0].Name)
+	assert.Equal(t, "2", events[0].Attributes["attempt"])
+}
@@ -0,0 +1,355 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/qiancijun/minirpc/codec"
+	"github.com/qiancijun/minirpc/common"
+	"github.com/qiancijun/minirpc/errs"
+	"github.com/qiancijun/minirpc/metadata"
+	"github.com/qiancijun/minirpc/trace"
+)
+
+// Stream 是流式调用在客户端的一端，同一条连接上的流以 StreamID 区分。
+// Send 与 Recv 可以在两个 goroutine 中同时调用，但各自不能并发调用
+type Stream struct {
+	ServiceMethod string
+	Trailer       metadata.MD // 服务端在流结束时设置的 trailer，Recv 返回错误后可读
+
+	c        *Client
+	id       uint64 // 等于发起流的请求的 Seq
+	elemType reflect.Type
+	window   uint32
+	consumed uint32             // 已经交给调用方、还没有归还给服务端的窗口
+	msgs     chan reflect.Value // 容量等于窗口，服务端遵守流控时不会写满
+
+	mu         sync.Mutex
+	credits    uint32        // 剩余可发送的消息条数
+	ready      chan struct{} // 服务端归还窗口时通知等待中的 Send
+	sendSeq    uint64
+	sendClosed bool
+
+	once     sync.Once
+	done     chan struct{} // 流结束时关闭
+	err      error         // 流结束的原因，正常结束为 io.EOF
+	onFinish func(err error)
+}
+
+// NewStream 发起流式调用，elem 是服务端消息类型的指针原型，例如 new(int)，用来解码收到的消息；
+// 客户端流与双向流方法没有 args，传 nil 即可，随后通过 Send 发送。ctx 结束时流被取消，服务端会停止收发
+func (c *Client) NewStream(ctx context.Context, serviceMethod string, args, elem interface{}) (*Stream, error) {
+	if !c.hasCapability(common.CapabilityStream) {
+		return nil, errs.ErrStreamUnsupported
+	}
+	elemType := reflect.TypeOf(elem)
+	if elemType == nil || elemType.Kind() != reflect.Ptr {
+		return nil, errors.New("rpc client: stream element prototype must be a pointer")
+	}
+	window := c.opt.StreamWindow
+	if window == 0 {
+		window = common.DefaultStreamWindow
+	}
+	s := &Stream{
+		ServiceMethod: serviceMethod,
+		c:             c,
+		elemType:      elemType,
+		window:        window,
+		msgs:          make(chan reflect.Value, window),
+		done:          make(chan struct{}),
+		credits:       window,
+		ready:         make(chan struct{}, 1),
+	}
+	if args == nil {
+		args = struct{}{}
+	}
+
+	finish := c.metrics.Begin(serviceMethod)
+	ctx, span := c.opt.Tracer.Start(ctx, serviceMethod, trace.KindClient)
+	s.onFinish = func(err error) {
+		if err == io.EOF {
+			err = nil
+		}
+		finish(err)
+		span.Finish(err)
+	}
+	if err := c.sendStream(trace.Inject(ctx), s, args); err != nil {
+		s.finish(err)
+		return nil, err
+	}
+	go s.watch(ctx)
+	return s, nil
+}
+
+// sendStream 登记流并发送发起流的请求，请求中的 Window 为双方的初始窗口
+func (c *Client) sendStream(ctx context.Context, s *Stream, args interface{}) error {
+	c.sending.Lock()
+	defer c.sending.Unlock()
+
+	c.mu.Lock()
+	if c.closing || c.shutdown || c.goAway {
+		c.mu.Unlock()
+		return errs.ErrShutdown
+	}
+	s.id = c.seq
+	c.seq++
+	c.streams[s.id] = s
+	c.mu.Unlock()
+
+	h := &codec.Header{
+		ServiceMethod: s.ServiceMethod,
+		Seq:           s.id,
+		StreamID:      s.id,
+		Window:        s.window,
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		h.Timeout = max(int64(time.Until(deadline)), 1)
+	}
+	if md, ok := metadata.FromOutgoingContext(ctx); ok {
+		h.Metadata = md
+	}
+	if err := c.cc.Write(h, args); err != nil {
+		c.removeStream(s.id)
+		return err
+	}
+	return nil
+}
+
+// sendWindowUpdate 归还 n 条消息的窗口，服务端据此继续发送
+func (c *Client) sendWindowUpdate(id uint64, n uint32) {
+	c.sending.Lock()
+	defer c.sending.Unlock()
+	h := &codec.Header{
+		StreamID: id,
+		Type:     codec.MessageWindowUpdate,
+		Window:   n,
+	}
+	if err := c.cc.Write(h, struct{}{}); err != nil {
+		log.Println("rpc client: send window update error: ", err)
+	}
+}
+
+func (c *Client) stream(id uint64) *Stream {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.streams[id]
+}
+
+func (c *Client) removeStream(id uint64) *Stream {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.streams[id]
+	delete(c.streams, id)
+	return s
+}
+
+// receiveStream 处理带有 StreamID 的消息，返回 false 表示消息不属于任何流
+func (c *Client) receiveStream(h *codec.Header) (bool, error) {
+	if h.StreamID == 0 {
+		return false, nil
+	}
+	switch h.Type {
+	case codec.MessageWindowUpdate:
+		if s := c.stream(h.StreamID); s != nil && h.Window > 0 {
+			s.release(h.Window)
+		}
+		return true, c.cc.ReadBody(nil)
+	case codec.MessageStream:
+		s := c.stream(h.StreamID)
+		if s == nil {
+			// 流已经被取消，丢弃还在路上的消息
+			return true, c.cc.ReadBody(nil)
+		}
+		v := reflect.New(s.elemType.Elem())
+		if err := c.cc.ReadBody(v.Interface()); err != nil {
+			return true, err
+		}
+		select {
+		case s.msgs <- v:
+		default:
+			// 服务端没有遵守流控，终止这个流
+			c.removeStream(h.StreamID)
+			go c.sendCancel(h.StreamID)
+			s.finish(errs.ErrStreamWindowExceeded)
+		}
+		return true, nil
+	case codec.MessageStreamEnd, codec.MessageResponse:
+		// 调用在开始之前被拒绝时，服务端以普通响应返回错误
+		s := c.removeStream(h.StreamID)
+		if s == nil {
+			return false, nil
+		}
+		s.Trailer = h.Metadata
+		err := c.cc.ReadBody(nil)
+		if h.Error != "" || h.Code != uint32(errs.CodeOK) {
+			s.finish(statusFromHeader(h))
+		} else if h.Type == codec.MessageResponse {
+			s.finish(errs.ErrStreamMismatch)
+		} else {
+			s.finish(io.EOF)
+		}
+		return true, err
+	}
+	return false, nil
+}
+
+// Recv 将下一条消息解码到 msg 中，msg 的类型需要与 NewStream 的 elem 一致；
+// 流正常结束时返回 io.EOF，否则返回服务端的错误或者取消、超时的错误
+func (s *Stream) Recv(msg interface{}) error {
+	select {
+	case v := <-s.msgs:
+		return s.deliver(v, msg)
+	case <-s.done:
+		// 先交付流结束前已经收到的消息
+		select {
+		case v := <-s.msgs:
+			return s.deliver(v, msg)
+		default:
+			return s.err
+		}
+	}
+}
+
+func (s *Stream) deliver(v reflect.Value, msg interface{}) error {
+	reflect.ValueOf(msg).Elem().Set(v.Elem())
+	s.consumed++
+	if s.consumed >= max(s.window/2, 1) {
+		select {
+		case <-s.done:
+		default:
+			s.c.sendWindowUpdate(s.id, s.consumed)
+		}
+		s.consumed = 0
+	}
+	return nil
+}
+
+// Send 向服务端发送一条消息，服务端来不及处理时阻塞。
+// 流已经结束时返回 io.EOF，结束的原因由 Recv 返回
+func (s *Stream) Send(msg interface{}) error {
+	s.mu.Lock()
+	closed := s.sendClosed
+	s.mu.Unlock()
+	if closed {
+		return errs.ErrStreamSendClosed
+	}
+	if err := s.acquire(); err != nil {
+		return err
+	}
+	s.sendSeq++
+	return s.c.sendStreamMessage(&codec.Header{
+		ServiceMethod: s.ServiceMethod,
+		Seq:           s.sendSeq,
+		StreamID:      s.id,
+		Type:          codec.MessageStream,
+	}, msg)
+}
+
+// CloseSend 半关闭流，告诉服务端不会再有消息，服务端方法的 in 随之关闭；
+// 之后仍然可以调用 Recv 接收服务端的消息
+func (s *Stream) CloseSend() error {
+	s.mu.Lock()
+	if s.sendClosed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.sendClosed = true
+	s.mu.Unlock()
+	select {
+	case <-s.done:
+		return nil
+	default:
+	}
+	return s.c.sendStreamMessage(&codec.Header{
+		ServiceMethod: s.ServiceMethod,
+		StreamID:      s.id,
+		Type:          codec.MessageStreamEnd,
+	}, struct{}{})
+}
+
+// CloseAndRecv 用于客户端流方法：半关闭流，然后等待服务端唯一的 reply
+func (s *Stream) CloseAndRecv(reply interface{}) error {
+	if err := s.CloseSend(); err != nil {
+		return err
+	}
+	if err := s.Recv(reply); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if err := s.Recv(reflect.New(s.elemType.Elem()).Interface()); err != io.EOF {
+		if err == nil {
+			return errs.ErrStreamMismatch
+		}
+		return err
+	}
+	return nil
+}
+
+// acquire 占用一条消息的窗口，窗口耗尽时等待服务端归还
+func (s *Stream) acquire() error {
+	for {
+		select {
+		case <-s.done:
+			return io.EOF
+		default:
+		}
+		s.mu.Lock()
+		if s.credits > 0 {
+			s.credits--
+			s.mu.Unlock()
+			return nil
+		}
+		s.mu.Unlock()
+		select {
+		case <-s.done:
+		case <-s.ready:
+		}
+	}
+}
+
+func (s *Stream) release(n uint32) {
+	s.mu.Lock()
+	s.credits += n
+	s.mu.Unlock()
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (c *Client) sendStreamMessage(h *codec.Header, msg interface{}) error {
+	c.sending.Lock()
+	defer c.sending.Unlock()
+	return c.cc.Write(h, msg)
+}
+
+// watch 在 ctx 结束时取消流
+func (s *Stream) watch(ctx context.Context) {
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		if s.c.removeStream(s.id) != nil {
+			s.c.sendCancel(s.id)
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			s.finish(errs.ErrClientCallTimeout)
+		} else {
+			s.finish(errs.ErrClientCallCanceled)
+		}
+	}
+}
+
+func (s *Stream) finish(err error) {
+	s.once.Do(func() {
+		s.err = err
+		close(s.done)
+		s.onFinish(err)
+	})
+}
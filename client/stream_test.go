@@ -0,0 +1,388 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qiancijun/minirpc/common"
+	"github.com/qiancijun/minirpc/errs"
+	"github.com/qiancijun/minirpc/metadata"
+	"github.com/qiancijun/minirpc/server"
+	"github.com/qiancijun/minirpc/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type Feed struct {
+	sent    atomic.Int32  // Flood 已经发送成功的消息数
+	stopped chan error    // Tail 与 Chat 结束时的错误
+	hold    chan struct{} // Hold 在它关闭之前不接收消息
+}
+
+// Count 依次发送 0 到 n-1，并通过 trailer 返回发送的条数
+func (f *Feed) Count(ctx context.Context, n int, stream service.ServerStream) error {
+	for i := 0; i < n; i++ {
+		if err := stream.Send(i); err != nil {
+			return err
+		}
+	}
+	return metadata.SetTrailer(ctx, metadata.Pairs("count", "done"))
+}
+
+// Fail 发送 n 条消息后返回错误
+func (f *Feed) Fail(n int, stream service.ServerStream) error {
+	for i := 0; i < n; i++ {
+		if err := stream.Send(i); err != nil {
+			return err
+		}
+	}
+	return errs.NewStatus(errs.CodeAborted, "feed broken")
+}
+
+// Flood 尽可能快地发送 n 条消息
+func (f *Feed) Flood(n int, stream service.ServerStream) error {
+	for i := 0; i < n; i++ {
+		if err := stream.Send(i); err != nil {
+			return err
+		}
+		f.sent.Add(1)
+	}
+	return nil
+}
+
+// Tail 持续发送直到调用被取消
+func (f *Feed) Tail(_ int, stream service.ServerStream) error {
+	for i := 0; ; i++ {
+		if err := stream.Send(i); err != nil {
+			f.stopped <- err
+			return err
+		}
+	}
+}
+
+// Drip 每隔 20ms 发送一条消息，共发送 n 条
+func (f *Feed) Drip(n int, stream service.ServerStream) error {
+	for i := 0; i < n; i++ {
+		time.Sleep(20 * time.Millisecond)
+		if err := stream.Send(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *Feed) Unary(n int, reply *int) error {
+	*reply = n
+	return nil
+}
+
+// Sum 接收客户端发送的所有整数，半关闭后返回它们的和
+func (f *Feed) Sum(in <-chan int, reply *int) error {
+	for n := range in {
+		*reply += n
+	}
+	return nil
+}
+
+// Hold 等到 hold 关闭后才开始接收，用来观察客户端到服务端方向的流控
+func (f *Feed) Hold(in <-chan int, reply *int) error {
+	<-f.hold
+	for range in {
+		*reply++
+	}
+	return nil
+}
+
+// Chat 把收到的每条消息加上前缀发回，客户端半关闭后结束
+func (f *Feed) Chat(ctx context.Context, in <-chan string, stream service.ServerStream) error {
+	for msg := range in {
+		if err := stream.Send("echo: " + msg); err != nil {
+			return err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		f.stopped <- err
+		return err
+	}
+	return nil
+}
+
+func startStreamServer(t *testing.T) (*Feed, string) {
+	feed := &Feed{stopped: make(chan error, 1), hold: make(chan struct{})}
+	s := server.NewServer()
+	require.NoError(t, s.Register(feed))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go s.Accept(l, server.DefaultServerOption)
+	return feed, l.Addr().String()
+}
+
+func TestClient_StreamOutlivesHandleTimeout(t *testing.T) {
+	t.Parallel()
+	s := server.NewServer()
+	require.NoError(t, s.Register(&Feed{}))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go s.Accept(l, server.ServerOption{Timeout: 50 * time.Millisecond})
+	client, err := Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer func() { _ = client.Close() }()
+
+	// 服务端的处理时限只约束一应一答的调用，持续 200ms 的流不会被截断
+	stream, err := client.NewStream(context.Background(), "Feed.Drip", 10, new(int))
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		var n int
+		require.NoError(t, stream.Recv(&n))
+		assert.Equal(t, i, n)
+	}
+	assert.Equal(t, io.EOF, stream.Recv(new(int)))
+
+	// 客户端的截止时间仍然生效
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	stream, err = client.NewStream(ctx, "Feed.Drip", 10, new(int))
+	require.NoError(t, err)
+	for err == nil {
+		err = stream.Recv(new(int))
+	}
+	assert.Equal(t, errs.CodeDeadlineExceeded, errs.CodeOf(err))
+}
+
+func TestClient_ServerStream(t *testing.T) {
+	t.Parallel()
+	_, addr := startStreamServer(t)
+	client, err := Dial("tcp", addr, &common.Option{StreamWindow: 4})
+	require.NoError(t, err)
+	defer func() { _ = client.Close() }()
+	ctx := context.Background()
+
+	t.Run("count", func(t *testing.T) {
+		stream, err := client.NewStream(ctx, "Feed.Count", 100, new(int))
+		require.NoError(t, err)
+		for i := 0; i < 100; i++ {
+			var n int
+			require.NoError(t, stream.Recv(&n))
+			assert.Equal(t, i, n)
+		}
+		var n int
+		assert.Equal(t, io.EOF, stream.Recv(&n))
+		assert.Equal(t, io.EOF, stream.Recv(&n))
+		assert.Equal(t, "done", stream.Trailer.Get("count"))
+	})
+
+	t.Run("error after messages", func(t *testing.T) {
+		stream, err := client.NewStream(ctx, "Feed.Fail", 3, new(int))
+		require.NoError(t, err)
+		var got []int
+		for {
+			var n int
+			if err = stream.Recv(&n); err != nil {
+				break
+			}
+			got = append(got, n)
+		}
+		assert.Equal(t, []int{0, 1, 2}, got)
+		assert.Equal(t, errs.CodeAborted, errs.CodeOf(err))
+		assert.Equal(t, "feed broken", err.Error())
+	})
+
+	t.Run("kind mismatch", func(t *testing.T) {
+		err := client.Call(ctx, "Feed.Count", 1, new(int))
+		assert.ErrorIs(t, err, errs.ErrStreamMismatch)
+
+		stream, err := client.NewStream(ctx, "Feed.Unary", 1, new(int))
+		require.NoError(t, err)
+		assert.ErrorIs(t, stream.Recv(new(int)), errs.ErrStreamMismatch)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		stream, err := client.NewStream(ctx, "Feed.Missing", 1, new(int))
+		require.NoError(t, err)
+		assert.ErrorIs(t, stream.Recv(new(int)), errs.ErrServiceNotFound)
+	})
+}
+
+func TestClient_ServerStreamFlowControl(t *testing.T) {
+	t.Parallel()
+	feed, addr := startStreamServer(t)
+	client, err := Dial("tcp", addr, &common.Option{StreamWindow: 4})
+	require.NoError(t, err)
+	defer func() { _ = client.Close() }()
+
+	stream, err := client.NewStream(context.Background(), "Feed.Flood", 50, new(int))
+	require.NoError(t, err)
+	// 客户端不接收时，服务端最多领先一个窗口
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int32(4), feed.sent.Load())
+
+	for i := 0; i < 50; i++ {
+		var n int
+		require.NoError(t, stream.Recv(&n))
+		assert.Equal(t, i, n)
+	}
+	assert.Equal(t, io.EOF, stream.Recv(new(int)))
+	assert.Equal(t, int32(50), feed.sent.Load())
+}
+
+func TestClient_ServerStreamCancel(t *testing.T) {
+	t.Parallel()
+	feed, addr := startStreamServer(t)
+	client, err := Dial("tcp", addr)
+	require.NoError(t, err)
+	defer func() { _ = client.Close() }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := client.NewStream(ctx, "Feed.Tail", 0, new(int))
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, stream.Recv(new(int)))
+	}
+	cancel()
+	for err == nil {
+		err = stream.Recv(new(int))
+	}
+	assert.ErrorIs(t, err, errs.ErrClientCallCanceled)
+
+	// 服务端的 Send 在取消后返回错误，方法随之结束
+	select {
+	case err := <-feed.stopped:
+		assert.True(t, errors.Is(err, context.Canceled))
+	case <-time.After(time.Second):
+		t.Fatal("server stream was not canceled")
+	}
+
+	// 连接仍然可用
+	var reply int
+	require.NoError(t, client.Call(context.Background(), "Feed.Unary", 7, &reply))
+	assert.Equal(t, 7, reply)
+}
+
+func TestClient_ClientStream(t *testing.T) {
+	t.Parallel()
+	_, addr := startStreamServer(t)
+	client, err := Dial("tcp", addr, &common.Option{StreamWindow: 4})
+	require.NoError(t, err)
+	defer func() { _ = client.Close() }()
+	ctx := context.Background()
+
+	stream, err := client.NewStream(ctx, "Feed.Sum", nil, new(int))
+	require.NoError(t, err)
+	for i := 1; i <= 100; i++ {
+		require.NoError(t, stream.Send(i))
+	}
+	var sum int
+	require.NoError(t, stream.CloseAndRecv(&sum))
+	assert.Equal(t, 5050, sum)
+	assert.ErrorIs(t, stream.Send(1), errs.ErrStreamSendClosed)
+
+	// 不发送任何消息直接半关闭
+	stream, err = client.NewStream(ctx, "Feed.Sum", nil, new(int))
+	require.NoError(t, err)
+	sum = -1
+	require.NoError(t, stream.CloseAndRecv(&sum))
+	assert.Equal(t, 0, sum)
+}
+
+func TestClient_ClientStreamFlowControl(t *testing.T) {
+	t.Parallel()
+	feed, addr := startStreamServer(t)
+	client, err := Dial("tcp", addr, &common.Option{StreamWindow: 4})
+	require.NoError(t, err)
+	defer func() { _ = client.Close() }()
+
+	stream, err := client.NewStream(context.Background(), "Feed.Hold", nil, new(int))
+	require.NoError(t, err)
+	var sent atomic.Int32
+	sendDone := make(chan error, 1)
+	go func() {
+		for i := 0; i < 20; i++ {
+			if err := stream.Send(i); err != nil {
+				sendDone <- err
+				return
+			}
+			sent.Add(1)
+		}
+		sendDone <- nil
+	}()
+	// 服务端不接收时，客户端最多领先一个窗口
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int32(4), sent.Load())
+
+	close(feed.hold)
+	require.NoError(t, <-sendDone)
+	var n int
+	require.NoError(t, stream.CloseAndRecv(&n))
+	assert.Equal(t, 20, n)
+}
+
+func TestClient_BidiStream(t *testing.T) {
+	t.Parallel()
+	_, addr := startStreamServer(t)
+	client, err := Dial("tcp", addr, &common.Option{StreamWindow: 2})
+	require.NoError(t, err)
+	defer func() { _ = client.Close() }()
+
+	stream, err := client.NewStream(context.Background(), "Feed.Chat", nil, new(string))
+	require.NoError(t, err)
+	// 一问一答
+	for _, msg := range []string{"hi", "how are you"} {
+		require.NoError(t, stream.Send(msg))
+		var reply string
+		require.NoError(t, stream.Recv(&reply))
+		assert.Equal(t, "echo: "+msg, reply)
+	}
+
+	// 先全部发送再接收，半关闭之后仍然能收到剩下的消息
+	go func() {
+		for i := 0; i < 10; i++ {
+			_ = stream.Send("x")
+		}
+		_ = stream.CloseSend()
+	}()
+	for i := 0; i < 10; i++ {
+		var reply string
+		require.NoError(t, stream.Recv(&reply))
+		assert.Equal(t, "echo: x", reply)
+	}
+	assert.Equal(t, io.EOF, stream.Recv(new(string)))
+}
+
+func TestClient_BidiStreamCancel(t *testing.T) {
+	t.Parallel()
+	feed, addr := startStreamServer(t)
+	client, err := Dial("tcp", addr)
+	require.NoError(t, err)
+	defer func() { _ = client.Close() }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled, err := client.NewStream(ctx, "Feed.Chat", nil, new(string))
+	require.NoError(t, err)
+	other, err := client.NewStream(context.Background(), "Feed.Chat", nil, new(string))
+	require.NoError(t, err)
+
+	require.NoError(t, canceled.Send("a"))
+	require.NoError(t, canceled.Recv(new(string)))
+	cancel()
+	assert.ErrorIs(t, canceled.Recv(new(string)), errs.ErrClientCallCanceled)
+	assert.Equal(t, io.EOF, canceled.Send("b"))
+	select {
+	case err := <-feed.stopped:
+		assert.True(t, errors.Is(err, context.Canceled))
+	case <-time.After(time.Second):
+		t.Fatal("server stream was not canceled")
+	}
+
+	// 取消只影响对应的流，同一连接上的其他流不受影响
+	require.NoError(t, other.Send("b"))
+	var reply string
+	require.NoError(t, other.Recv(&reply))
+	assert.Equal(t, "echo: b", reply)
+	require.NoError(t, other.CloseSend())
+	assert.Equal(t, io.EOF, other.Recv(new(string)))
+}
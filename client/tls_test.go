@@ -0,0 +1,139 @@
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/qiancijun/minirpc/common"
+	"github.com/qiancijun/minirpc/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type Secure int
+
+// Identity 返回客户端证书中的身份
+func (s Secure) Identity(ctx context.Context, args int, reply *string) error {
+	p, _ := server.PeerFromContext(ctx)
+	*reply = p.Identity()
+	return nil
+}
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "minirpc test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue 签发一张证书，server 为 true 时签发给 127.0.0.1 的服务端证书
+func (ca *testCA) issue(t *testing.T, cn string, server bool) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if server {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		tmpl.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func startTLSServer(t *testing.T, cfg *tls.Config) string {
+	s := server.NewServer()
+	require.NoError(t, s.Register(new(Bar)))
+	require.NoError(t, s.Register(new(Secure)))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go s.Accept(l, server.ServerOption{TLSConfig: cfg})
+	t.Cleanup(s.Close)
+	return l.Addr().String()
+}
+
+func TestClient_TLS(t *testing.T) {
+	t.Parallel()
+	ca := newTestCA(t)
+	addr := startTLSServer(t, &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, "server", true)},
+	})
+
+	t.Run("tls scheme", func(t *testing.T) {
+		client, err := XDial("tls@"+addr, &common.Option{TLSConfig: &tls.Config{RootCAs: ca.pool}})
+		require.NoError(t, err)
+		defer func() { _ = client.Close() }()
+		var reply int
+		assert.NoError(t, client.Call(context.Background(), "Bar.Double", 2, &reply))
+		assert.Equal(t, 4, reply)
+	})
+	t.Run("untrusted server", func(t *testing.T) {
+		_, err := XDial("tls@" + addr)
+		assert.Error(t, err)
+	})
+	t.Run("plain client", func(t *testing.T) {
+		_, err := Dial("tcp", addr, &common.Option{ConnectTimeout: time.Second})
+		assert.Error(t, err)
+	})
+}
+
+func TestClient_MutualTLS(t *testing.T) {
+	t.Parallel()
+	ca := newTestCA(t)
+	addr := startTLSServer(t, &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, "server", true)},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    ca.pool,
+	})
+
+	t.Run("with client certificate", func(t *testing.T) {
+		client, err := Dial("tcp", addr, &common.Option{TLSConfig: &tls.Config{
+			RootCAs:      ca.pool,
+			Certificates: []tls.Certificate{ca.issue(t, "order-service", false)},
+		}})
+		require.NoError(t, err)
+		defer func() { _ = client.Close() }()
+		var identity string
+		assert.NoError(t, client.Call(context.Background(), "Secure.Identity", 0, &identity))
+		assert.Equal(t, "order-service", identity)
+	})
+	t.Run("without client certificate", func(t *testing.T) {
+		_, err := Dial("tcp", addr, &common.Option{TLSConfig: &tls.Config{RootCAs: ca.pool}})
+		assert.Error(t, err)
+	})
+}
@@ -2,10 +2,32 @@ package codec
 
 import "io"
 
+type MessageType uint8
+
+const (
+	MessageRequest MessageType = iota
+	MessageResponse
+	MessageCancel       // 客户端放弃等待，通知服务端取消 Seq 对应的请求
+	MessageGoAway       // 服务端即将关闭连接，客户端不应再发送新的请求
+	MessageStream       // 流中的一条消息，StreamID 标识所属的流，Seq 为该方向上消息的序号
+	MessageStreamEnd    // 流结束：客户端发送表示半关闭，服务端发送时携带最终的错误与 trailer
+	MessageWindowUpdate // 接收方处理完消息后归还发送窗口，增量记录在 Window 中
+	MessagePing         // 心跳探测，收到的一方立即以 MessagePong 回应
+	MessagePong         // 心跳应答，Seq 与对应的 MessagePing 相同
+)
+
 type Header struct {
-	ServiceMethod string // 形如 "Service.Method"
-	Seq           uint64 // 请求序列号
-	Error         string // 请求错误信息，客户端置空
+	ServiceMethod string            // 形如 "Service.Method"
+	Seq           uint64            // 请求序列号
+	Error         string            // 请求错误信息，客户端置空
+	Code          uint32            // 错误码，取值见 errs.Code
+	Details       map[string]string // 错误的结构化信息
+	Type          MessageType       // 消息类型，分帧协议下与帧头中的类型一致
+	Timeout       int64             // 客户端剩余的等待时间（纳秒），0 表示没有截止时间
+	Metadata      map[string]string // 请求中为客户端附带的元数据，响应中为服务端设置的 trailer
+	StreamID      uint64            // 流式调用的标识，等于发起流的请求序列号，一应一答的调用为 0
+	OneWay        bool              // 单向调用，服务端执行方法后不发送响应
+	Window        uint32            // 流控窗口：请求中为双方的初始窗口，窗口更新消息中为增量，单位为消息条数
 }
 
 type Codec interface {
@@ -24,9 +46,32 @@ const (
 	JsonType Type = "application/json"
 )
 
-var NewCodecFuncMap  map[Type]NewCodecFunc
+var (
+	NewCodecFuncMap map[Type]NewCodecFunc
+	SerializerMap   map[Type]Serializer
+)
 
 func init() {
 	NewCodecFuncMap = make(map[Type]NewCodecFunc)
 	NewCodecFuncMap[GobType] = NewGobCodec
-}
\ No newline at end of file
+	NewCodecFuncMap[JsonType] = NewJsonCodec
+
+	SerializerMap = make(map[Type]Serializer)
+	SerializerMap[GobType] = GobSerializer{}
+	SerializerMap[JsonType] = JsonSerializer{}
+}
+
+// NewCodec 根据协议版本创建编解码器：version 为 0 时使用不分帧的流式格式，
+// 否则使用 FrameCodec，maxSize 限制单帧大小
+func NewCodec(conn io.ReadWriteCloser, t Type, version uint8, maxSize uint32) Codec {
+	if version == 0 {
+		if f := NewCodecFuncMap[t]; f != nil {
+			return f(conn)
+		}
+		return nil
+	}
+	if s := SerializerMap[t]; s != nil && version == FrameVersion {
+		return NewFrameCodec(conn, s, maxSize)
+	}
+	return nil
+}
@@ -0,0 +1,294 @@
+package codec
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"log"
+
+	"github.com/qiancijun/minirpc/errs"
+)
+
+// 帧格式（大端序）：
+//
+//	| magic(2) | version(1) | type(1) | header length(4) | body length(4) | header | body |
+//
+// 帧头中携带 header 和 body 的长度，接收方无需解码即可跳过整个 body，
+// 代理也可以只解析帧头完成转发。body 由 Serializer 编码，header 使用固定的二进制布局（大端序），
+// 不随每帧重复携带类型信息，Type 由帧头携带：
+//
+//	| seq(8) | stream id(8) | timeout(8) | code(4) | window(4) | flags(1) |
+//	| service method | error | details | metadata |
+//
+// 字符串为 uvarint 长度加内容，map 为 uvarint 条目数加依次排列的键和值
+const (
+	FrameMagic          uint16 = 0x3bef
+	FrameVersion        uint8  = 1
+	FrameHeaderSize            = 12
+	DefaultMaxFrameSize uint32 = 16 << 20
+)
+
+type FrameHeader struct {
+	Magic     uint16
+	Version   uint8
+	Type      MessageType
+	HeaderLen uint32
+	BodyLen   uint32
+}
+
+func (f *FrameHeader) encode(b []byte) {
+	binary.BigEndian.PutUint16(b[0:2], f.Magic)
+	b[2] = f.Version
+	b[3] = byte(f.Type)
+	binary.BigEndian.PutUint32(b[4:8], f.HeaderLen)
+	binary.BigEndian.PutUint32(b[8:12], f.BodyLen)
+}
+
+// ReadFrameHeader 读取并校验一个帧头
+func ReadFrameHeader(r io.Reader) (FrameHeader, error) {
+	var b [FrameHeaderSize]byte
+	var f FrameHeader
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return f, err
+	}
+	f.Magic = binary.BigEndian.Uint16(b[0:2])
+	f.Version = b[2]
+	f.Type = MessageType(b[3])
+	f.HeaderLen = binary.BigEndian.Uint32(b[4:8])
+	f.BodyLen = binary.BigEndian.Uint32(b[8:12])
+	if f.Magic != FrameMagic {
+		return f, errs.ErrInvalidFrameMagic
+	}
+	if f.Version != FrameVersion {
+		return f, errs.ErrUnsupportedFrameVersion
+	}
+	return f, nil
+}
+
+const (
+	headerFixedSize = 33
+
+	headerFlagOneWay = 1 << 0
+)
+
+// appendHeader 把 h 按照固定布局追加到 b 之后
+func appendHeader(b []byte, h *Header) []byte {
+	b = binary.BigEndian.AppendUint64(b, h.Seq)
+	b = binary.BigEndian.AppendUint64(b, h.StreamID)
+	b = binary.BigEndian.AppendUint64(b, uint64(h.Timeout))
+	b = binary.BigEndian.AppendUint32(b, h.Code)
+	b = binary.BigEndian.AppendUint32(b, h.Window)
+	var flags byte
+	if h.OneWay {
+		flags |= headerFlagOneWay
+	}
+	b = append(b, flags)
+	b = appendString(b, h.ServiceMethod)
+	b = appendString(b, h.Error)
+	b = appendStringMap(b, h.Details)
+	return appendStringMap(b, h.Metadata)
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func appendStringMap(b []byte, m map[string]string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(m)))
+	for k, v := range m {
+		b = appendString(b, k)
+		b = appendString(b, v)
+	}
+	return b
+}
+
+// decodeHeader 解析 appendHeader 编码的数据并覆盖 h 的所有字段（Type 除外），
+// 末尾多出的数据留给以后追加的字段，直接忽略
+func decodeHeader(data []byte, h *Header) error {
+	if len(data) < headerFixedSize {
+		return errs.ErrMalformedHeader
+	}
+	h.Seq = binary.BigEndian.Uint64(data[0:8])
+	h.StreamID = binary.BigEndian.Uint64(data[8:16])
+	h.Timeout = int64(binary.BigEndian.Uint64(data[16:24]))
+	h.Code = binary.BigEndian.Uint32(data[24:28])
+	h.Window = binary.BigEndian.Uint32(data[28:32])
+	h.OneWay = data[32]&headerFlagOneWay != 0
+	r := headerReader{b: data[headerFixedSize:]}
+	h.ServiceMethod = r.string()
+	h.Error = r.string()
+	h.Details = r.stringMap()
+	h.Metadata = r.stringMap()
+	return r.err
+}
+
+// headerReader 依次读取 header 中变长的字段，数据不完整时记录 ErrMalformedHeader
+type headerReader struct {
+	b   []byte
+	err error
+}
+
+func (r *headerReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	n, l := binary.Uvarint(r.b)
+	if l <= 0 {
+		r.err = errs.ErrMalformedHeader
+		return 0
+	}
+	r.b = r.b[l:]
+	return n
+}
+
+func (r *headerReader) string() string {
+	n := r.uvarint()
+	if r.err != nil {
+		return ""
+	}
+	if n > uint64(len(r.b)) {
+		r.err = errs.ErrMalformedHeader
+		return ""
+	}
+	s := string(r.b[:n])
+	r.b = r.b[n:]
+	return s
+}
+
+func (r *headerReader) stringMap() map[string]string {
+	n := r.uvarint()
+	if r.err != nil || n == 0 {
+		return nil
+	}
+	// 每个条目至少占两个字节，提前拒绝伪造的条目数
+	if n > uint64(len(r.b))/2 {
+		r.err = errs.ErrMalformedHeader
+		return nil
+	}
+	m := make(map[string]string, n)
+	for i := uint64(0); i < n; i++ {
+		k := r.string()
+		m[k] = r.string()
+	}
+	if r.err != nil {
+		return nil
+	}
+	return m
+}
+
+type FrameCodec struct {
+	conn    io.ReadWriteCloser
+	r       *bufio.Reader
+	buf     *bufio.Writer
+	s       Serializer
+	maxSize uint32
+	bodyLen uint32 // 当前帧中尚未读取的 body 长度
+}
+
+// NewFrameCodec 创建一个以 s 序列化 header 与 body、以二进制帧承载的编解码器，
+// maxSize 限制单帧 header 与 body 的总长度，为 0 时使用 DefaultMaxFrameSize
+func NewFrameCodec(conn io.ReadWriteCloser, s Serializer, maxSize uint32) Codec {
+	if maxSize == 0 {
+		maxSize = DefaultMaxFrameSize
+	}
+	return &FrameCodec{
+		conn:    conn,
+		r:       bufio.NewReader(conn),
+		buf:     bufio.NewWriter(conn),
+		s:       s,
+		maxSize: maxSize,
+	}
+}
+
+var _ Codec = (*FrameCodec)(nil)
+
+// Close implements Codec.
+func (f *FrameCodec) Close() error {
+	return f.conn.Close()
+}
+
+// ReadHeader implements Codec.
+func (f *FrameCodec) ReadHeader(header *Header) error {
+	// 上一帧的 body 没有被读取时直接跳过
+	if err := f.discardBody(); err != nil {
+		return err
+	}
+	fh, err := ReadFrameHeader(f.r)
+	if err != nil {
+		return err
+	}
+	if uint64(fh.HeaderLen)+uint64(fh.BodyLen) > uint64(f.maxSize) {
+		return errs.ErrFrameTooLarge
+	}
+	data := make([]byte, fh.HeaderLen)
+	if _, err := io.ReadFull(f.r, data); err != nil {
+		return err
+	}
+	f.bodyLen = fh.BodyLen
+	if err := decodeHeader(data, header); err != nil {
+		return err
+	}
+	header.Type = fh.Type
+	return nil
+}
+
+// ReadBody implements Codec.
+// body 为 nil 时按照帧头记录的长度直接丢弃，不做任何解码
+func (f *FrameCodec) ReadBody(body interface{}) error {
+	if body == nil {
+		return f.discardBody()
+	}
+	data := make([]byte, f.bodyLen)
+	f.bodyLen = 0
+	if _, err := io.ReadFull(f.r, data); err != nil {
+		return err
+	}
+	return f.s.Unmarshal(data, body)
+}
+
+// Write implements Codec.
+func (f *FrameCodec) Write(h *Header, body interface{}) error {
+	hdata := appendHeader(make([]byte, 0, 64), h)
+	bdata, err := f.s.Marshal(body)
+	if err != nil {
+		log.Println("rpc codec: frame error encoding body: ", err)
+		return err
+	}
+	if uint64(len(hdata))+uint64(len(bdata)) > uint64(f.maxSize) {
+		return errs.ErrFrameTooLarge
+	}
+
+	defer func() {
+		err := f.buf.Flush()
+		if err != nil {
+			_ = f.Close()
+		}
+	}()
+
+	fh := FrameHeader{
+		Magic:     FrameMagic,
+		Version:   FrameVersion,
+		Type:      h.Type,
+		HeaderLen: uint32(len(hdata)),
+		BodyLen:   uint32(len(bdata)),
+	}
+	var b [FrameHeaderSize]byte
+	fh.encode(b[:])
+	for _, p := range [][]byte{b[:], hdata, bdata} {
+		if _, err := f.buf.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FrameCodec) discardBody() error {
+	if f.bodyLen == 0 {
+		return nil
+	}
+	n := f.bodyLen
+	f.bodyLen = 0
+	_, err := f.r.Discard(int(n))
+	return err
+}
@@ -0,0 +1,150 @@
+package codec
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/qiancijun/minirpc/errs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newBufferFrameCodec(buf *bytes.Buffer, s Serializer, maxSize uint32) *FrameCodec {
+	return NewFrameCodec(struct {
+		io.Reader
+		io.Writer
+		io.Closer
+	}{
+		Reader: buf,
+		Writer: buf,
+		Closer: io.NopCloser(nil),
+	}, s, maxSize).(*FrameCodec)
+}
+
+func TestFrameCodec_ReadWrite(t *testing.T) {
+	type TestBody struct {
+		Name string
+		Age  int
+	}
+
+	testBody := &TestBody{
+		Name: "Alice",
+		Age:  30,
+	}
+
+	for typ, s := range SerializerMap {
+		t.Run(string(typ), func(t *testing.T) {
+			var buf bytes.Buffer
+			clientCodec := newBufferFrameCodec(&buf, s, 0)
+			serverCodec := newBufferFrameCodec(&buf, s, 0)
+
+			// 第一条消息的 body 被跳过，第二条正常读取
+			require.NoError(t, clientCodec.Write(&Header{ServiceMethod: "Test.Skip", Seq: 1}, testBody))
+			md := map[string]string{"trace-id": "abc"}
+			require.NoError(t, clientCodec.Write(&Header{ServiceMethod: "Test.Method", Seq: 2, Type: MessageResponse, Metadata: md}, testBody))
+
+			var h Header
+			require.NoError(t, serverCodec.ReadHeader(&h))
+			assert.Equal(t, "Test.Skip", h.ServiceMethod, "ServiceMethod should match")
+			assert.Equal(t, MessageRequest, h.Type, "Type should match")
+			require.NoError(t, serverCodec.ReadBody(nil), "ReadBody(nil) should discard the body")
+
+			require.NoError(t, serverCodec.ReadHeader(&h))
+			assert.Equal(t, uint64(2), h.Seq, "Seq should match")
+			assert.Equal(t, MessageResponse, h.Type, "Type should match")
+			assert.Equal(t, md, h.Metadata, "Metadata should match")
+
+			var body TestBody
+			require.NoError(t, serverCodec.ReadBody(&body))
+			assert.Equal(t, *testBody, body, "Body should match")
+			assert.Equal(t, 0, buf.Len(), "all frames should be consumed")
+		})
+	}
+}
+
+func TestFrameCodec_SkipUnreadBody(t *testing.T) {
+	var buf bytes.Buffer
+	clientCodec := newBufferFrameCodec(&buf, GobSerializer{}, 0)
+	serverCodec := newBufferFrameCodec(&buf, GobSerializer{}, 0)
+
+	require.NoError(t, clientCodec.Write(&Header{Seq: 1}, "first"))
+	require.NoError(t, clientCodec.Write(&Header{Seq: 2}, "second"))
+
+	// 不调用 ReadBody 直接读取下一个 header
+	var h Header
+	require.NoError(t, serverCodec.ReadHeader(&h))
+	require.NoError(t, serverCodec.ReadHeader(&h))
+	assert.Equal(t, uint64(2), h.Seq)
+	var body string
+	require.NoError(t, serverCodec.ReadBody(&body))
+	assert.Equal(t, "second", body)
+}
+
+func TestFrameCodec_MaxSize(t *testing.T) {
+	var buf bytes.Buffer
+	small := newBufferFrameCodec(&buf, GobSerializer{}, 64)
+	large := newBufferFrameCodec(&buf, GobSerializer{}, 0)
+
+	payload := string(make([]byte, 128))
+	assert.Equal(t, errs.ErrFrameTooLarge, small.Write(&Header{Seq: 1}, payload))
+	assert.Equal(t, 0, buf.Len(), "oversized frame should not be written")
+
+	require.NoError(t, large.Write(&Header{Seq: 1}, payload))
+	var h Header
+	assert.Equal(t, errs.ErrFrameTooLarge, small.ReadHeader(&h))
+}
+
+func TestFrameCodec_Header(t *testing.T) {
+	t.Run("round trip", func(t *testing.T) {
+		h := &Header{
+			ServiceMethod: "Foo.Sum",
+			Seq:           7,
+			Error:         "boom",
+			Code:          3,
+			Details:       map[string]string{"field": "a"},
+			Type:          MessageStream,
+			Timeout:       int64(1500),
+			Metadata:      map[string]string{"trace-id": "abc", "user": ""},
+			StreamID:      7,
+			OneWay:        true,
+			Window:        16,
+		}
+		var buf bytes.Buffer
+		require.NoError(t, newBufferFrameCodec(&buf, GobSerializer{}, 0).Write(h, "body"))
+		// 读取时覆盖所有字段，不会残留上一次的值
+		got := Header{ServiceMethod: "stale", Metadata: map[string]string{"stale": "1"}}
+		require.NoError(t, newBufferFrameCodec(&buf, GobSerializer{}, 0).ReadHeader(&got))
+		assert.Equal(t, *h, got)
+	})
+	t.Run("size", func(t *testing.T) {
+		// header 使用固定布局，不再随每帧携带 gob 的类型信息
+		var buf bytes.Buffer
+		require.NoError(t, newBufferFrameCodec(&buf, GobSerializer{}, 0).Write(&Header{ServiceMethod: "Foo.Sum", Seq: 1}, 1))
+		fh, err := ReadFrameHeader(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, uint32(headerFixedSize+1+len("Foo.Sum")+3), fh.HeaderLen)
+	})
+	t.Run("malformed", func(t *testing.T) {
+		data := appendHeader(nil, &Header{ServiceMethod: "Foo.Sum", Metadata: map[string]string{"k": "v"}})
+		for _, n := range []int{0, headerFixedSize - 1, headerFixedSize + 3, len(data) - 1} {
+			var h Header
+			assert.Equal(t, errs.ErrMalformedHeader, decodeHeader(data[:n], &h), "truncated at %d", n)
+		}
+		var h Header
+		assert.NoError(t, decodeHeader(data, &h))
+	})
+}
+
+func TestReadFrameHeader(t *testing.T) {
+	t.Run("invalid magic", func(t *testing.T) {
+		_, err := ReadFrameHeader(bytes.NewReader(make([]byte, FrameHeaderSize)))
+		assert.Equal(t, errs.ErrInvalidFrameMagic, err)
+	})
+	t.Run("unsupported version", func(t *testing.T) {
+		b := make([]byte, FrameHeaderSize)
+		(&FrameHeader{Magic: FrameMagic, Version: FrameVersion + 1}).encode(b)
+		_, err := ReadFrameHeader(bytes.NewReader(b))
+		assert.Equal(t, errs.ErrUnsupportedFrameVersion, err)
+	})
+}
@@ -60,4 +60,4 @@ func (g *GobCodec) Write(h *Header, body interface{}) error {
 		return err
 	}
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,68 @@
+package codec
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+)
+
+type JsonCodec struct {
+	conn io.ReadWriteCloser // conn 由构造函数传入，通常是通过 TCP 或者 Unix 建立 socket 得到的链接实例
+	buf  *bufio.Writer      // 防止阻塞创建带缓冲的 Writer
+	dec  *json.Decoder
+	enc  *json.Encoder
+}
+
+func NewJsonCodec(conn io.ReadWriteCloser) Codec {
+	buf := bufio.NewWriter(conn)
+	return &JsonCodec{
+		conn: conn,
+		buf:  buf,
+		dec:  json.NewDecoder(conn),
+		enc:  json.NewEncoder(buf),
+	}
+}
+
+var _ Codec = (*JsonCodec)(nil)
+
+// Close implements Codec.
+func (j *JsonCodec) Close() error {
+	return j.conn.Close()
+}
+
+// ReadBody implements Codec.
+func (j *JsonCodec) ReadBody(body interface{}) error {
+	// 与 gob 不同，json 无法解码到 nil，这里读出原始数据后直接丢弃
+	if body == nil {
+		var raw json.RawMessage
+		return j.dec.Decode(&raw)
+	}
+	return j.dec.Decode(body)
+}
+
+// ReadHeader implements Codec.
+func (j *JsonCodec) ReadHeader(header *Header) error {
+	return j.dec.Decode(header)
+}
+
+// Write implements Codec.
+func (j *JsonCodec) Write(h *Header, body interface{}) error {
+	defer func() {
+		err := j.buf.Flush()
+		if err != nil {
+			_ = j.Close()
+		}
+	}()
+
+	if err := j.enc.Encode(h); err != nil {
+		log.Println("rpc codec: json error encoding header: ", err)
+		return err
+	}
+
+	if err := j.enc.Encode(body); err != nil {
+		log.Println("rpc codec: json error encoding body: ", err)
+		return err
+	}
+	return nil
+}
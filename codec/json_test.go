@@ -0,0 +1,98 @@
+package codec
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJsonCodec_ReadWrite(t *testing.T) {
+	// 使用 bytes.Buffer 作为双向通信缓冲区
+	var buf bytes.Buffer
+
+	// 创建两个独立的编解码器，共享同一个缓冲区
+	serverCodec := NewJsonCodec(struct {
+		io.Reader
+		io.Writer
+		io.Closer
+	}{
+		Reader: &buf,
+		Writer: &buf,
+		Closer: io.NopCloser(nil),
+	}).(*JsonCodec)
+
+	clientCodec := NewJsonCodec(struct {
+		io.Reader
+		io.Writer
+		io.Closer
+	}{
+		Reader: &buf,
+		Writer: &buf,
+		Closer: io.NopCloser(nil),
+	}).(*JsonCodec)
+
+	// 测试数据
+	type TestBody struct {
+		Name string
+		Age  int
+	}
+
+	testHeader := &Header{
+		ServiceMethod: "Test.Method",
+		Seq:           12345,
+		Error:         "",
+	}
+
+	testBody := &TestBody{
+		Name: "Alice",
+		Age:  30,
+	}
+
+	t.Run("Write and Read", func(t *testing.T) {
+		// 重置缓冲区
+		buf.Reset()
+
+		// 客户端写入
+		err := clientCodec.Write(testHeader, testBody)
+		require.NoError(t, err, "Write should not error")
+		require.NoError(t, clientCodec.buf.Flush(), "Flush should not error")
+
+		// 服务端读取
+		var h Header
+		err = serverCodec.ReadHeader(&h)
+		require.NoError(t, err, "ReadHeader should not error")
+
+		assert.Equal(t, testHeader.ServiceMethod, h.ServiceMethod, "ServiceMethod should match")
+		assert.Equal(t, testHeader.Seq, h.Seq, "Seq should match")
+		assert.Equal(t, testHeader.Error, h.Error, "Error should match")
+
+		var body TestBody
+		err = serverCodec.ReadBody(&body)
+		require.NoError(t, err, "ReadBody should not error")
+
+		assert.Equal(t, testBody.Name, body.Name, "Body Name should match")
+		assert.Equal(t, testBody.Age, body.Age, "Body Age should match")
+	})
+
+	t.Run("Discard Body", func(t *testing.T) {
+		buf.Reset()
+
+		// 连续写入两条消息，第一条的 body 被丢弃后第二条仍能正常读取
+		require.NoError(t, clientCodec.Write(&Header{ServiceMethod: "Test.Skip", Seq: 1}, testBody))
+		require.NoError(t, clientCodec.Write(&Header{ServiceMethod: "Test.Method", Seq: 2}, testBody))
+
+		var h Header
+		require.NoError(t, serverCodec.ReadHeader(&h))
+		assert.Equal(t, uint64(1), h.Seq, "Seq should match")
+		require.NoError(t, serverCodec.ReadBody(nil), "ReadBody(nil) should discard the body")
+
+		require.NoError(t, serverCodec.ReadHeader(&h))
+		assert.Equal(t, uint64(2), h.Seq, "Seq should match")
+		var body TestBody
+		require.NoError(t, serverCodec.ReadBody(&body))
+		assert.Equal(t, *testBody, body, "Body should match")
+	})
+}
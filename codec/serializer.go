@@ -0,0 +1,47 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+)
+
+// Serializer 负责把单个值编码为独立的字节序列，供分帧协议使用
+type Serializer interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+type GobSerializer struct{}
+
+// Marshal implements Serializer.
+// 每个值使用独立的 Encoder，保证帧与帧之间没有类型信息依赖，可以单独丢弃
+func (GobSerializer) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal implements Serializer.
+func (GobSerializer) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type JsonSerializer struct{}
+
+// Marshal implements Serializer.
+func (JsonSerializer) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal implements Serializer.
+func (JsonSerializer) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+var (
+	_ Serializer = GobSerializer{}
+	_ Serializer = JsonSerializer{}
+)
@@ -0,0 +1,47 @@
+package common
+
+import (
+	"bufio"
+	"io"
+)
+
+// handshakeConn 将握手阶段 json.Decoder 预读的数据与原始连接拼接起来，
+// 并在第一次读取时跳过 json.Encoder 在 Option 之后写入的换行符
+type handshakeConn struct {
+	r       *bufio.Reader
+	conn    io.ReadWriteCloser
+	skipped bool
+}
+
+// NewHandshakeConn 返回握手完成后交给编解码器使用的连接，buffered 通常是 json.Decoder.Buffered()
+func NewHandshakeConn(conn io.ReadWriteCloser, buffered io.Reader) io.ReadWriteCloser {
+	return &handshakeConn{
+		r:    bufio.NewReader(io.MultiReader(buffered, conn)),
+		conn: conn,
+	}
+}
+
+func (c *handshakeConn) Read(p []byte) (int, error) {
+	if !c.skipped {
+		for {
+			b, err := c.r.ReadByte()
+			if err != nil {
+				return 0, err
+			}
+			if b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+				_ = c.r.UnreadByte()
+				break
+			}
+		}
+		c.skipped = true
+	}
+	return c.r.Read(p)
+}
+
+func (c *handshakeConn) Write(p []byte) (int, error) {
+	return c.conn.Write(p)
+}
+
+func (c *handshakeConn) Close() error {
+	return c.conn.Close()
+}
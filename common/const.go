@@ -6,7 +6,14 @@ const (
 	Connected            = "200 Connected to Mini RPC"
 	DefaultRPCPath       = "/_minirpc_"
 	DefaultDebugPath     = "/debug/minirpc"
+	DefaultMetricsPath   = "/metrics"
 	DefaultTimeout       = time.Minute * 5
 	DefaultPath          = "/_minirpc_/registry"
 	DefaultUpdateTimeout = time.Second * 10
+	// DefaultStreamWindow 是流的初始发送窗口，发送方最多领先接收方这么多条消息
+	DefaultStreamWindow = 32
+	// AuthorizationKey 是请求元数据中携带单次调用凭证的键，优先于握手时提交的 Option.Token
+	AuthorizationKey = "authorization"
+	// DefaultKeepaliveTimeout 是只设置了心跳间隔时等待 pong 的时间
+	DefaultKeepaliveTimeout = time.Second * 20
 )
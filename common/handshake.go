@@ -0,0 +1,61 @@
+package common
+
+import (
+	"github.com/qiancijun/minirpc/codec"
+	"github.com/qiancijun/minirpc/errs"
+)
+
+type RejectReason int
+
+const (
+	HandshakeAccepted RejectReason = iota
+	RejectInvalidMagicNumber
+	RejectUnsupportedCodec
+	RejectUnsupportedVersion
+	RejectUnauthenticated
+)
+
+// 服务端能力，客户端据此决定是否启用对应的特性
+const (
+	CapabilityFrame  = "frame"
+	CapabilityCancel = "cancel"
+	CapabilityStream = "stream"
+	// CapabilityKeepalive 表示服务端会回应 ping，客户端据此决定是否发送心跳
+	CapabilityKeepalive = "keepalive"
+)
+
+// HandshakeResponse 是服务端对 Option 的应答，Version 为 0 的旧版客户端不会收到该应答
+type HandshakeResponse struct {
+	CodecType    codec.Type
+	Version      uint8
+	Capabilities []string
+	Reason       RejectReason
+	Message      string
+}
+
+// Err 将拒绝原因转换为 errs 包中对应的错误，接受握手时返回 nil
+func (r *HandshakeResponse) Err() error {
+	switch r.Reason {
+	case HandshakeAccepted:
+		return nil
+	case RejectInvalidMagicNumber:
+		return errs.ErrInvalidMagicNumber
+	case RejectUnsupportedCodec:
+		return errs.ErrUnsupportedCodec
+	case RejectUnsupportedVersion:
+		return errs.ErrUnsupportedVersion
+	case RejectUnauthenticated:
+		return errs.ErrUnauthenticated
+	default:
+		return errs.ErrHandshakeRejected
+	}
+}
+
+func (r *HandshakeResponse) HasCapability(capability string) bool {
+	for _, c := range r.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
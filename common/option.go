@@ -1,9 +1,12 @@
 package common
 
 import (
+	"crypto/tls"
 	"time"
 
 	"github.com/qiancijun/minirpc/codec"
+	"github.com/qiancijun/minirpc/metrics"
+	"github.com/qiancijun/minirpc/trace"
 )
 
 const MagicNumber = 0x3bef5c
@@ -11,12 +14,26 @@ const MagicNumber = 0x3bef5c
 type Option struct {
 	MagicNumber    int
 	CodecType      codec.Type
+	Version        uint8  // 协议版本，0 表示不分帧的流式格式，客户端未设置时使用 DefaultOption.Version
+	Unframed       bool   `json:"-"` // 客户端为 true 时忽略 Version，使用不分帧的流式格式连接旧版服务端
+	MaxMessageSize uint32 // 分帧协议下单帧的最大长度，0 表示使用默认值
 	ConnectTimeout time.Duration
 	HandleTimeout  time.Duration
+	StreamWindow   uint32            // 流式调用的初始窗口，0 表示使用 DefaultStreamWindow
+	Token          string            // 握手时提交给服务端认证的凭证，对整个连接有效
+	TLSConfig      *tls.Config       `json:"-"` // 不为 nil 时通过 TLS 建立连接，不参与握手协商
+	Metrics        *metrics.Registry `json:"-"` // 记录调用指标的注册表，nil 表示 metrics.DefaultRegistry
+	Tracer         *trace.Tracer     `json:"-"` // 不为 nil 时为每次调用创建 span，并通过元数据传递给服务端
+
+	// 服务端支持心跳时，连接上 KeepaliveInterval 内没有收到任何消息就发送 ping，
+	// 之后 KeepaliveTimeout 内仍然没有消息则关闭连接，调用以 errs.ErrKeepaliveTimeout 失败
+	KeepaliveInterval time.Duration `json:"-"` // 0 表示不发送心跳
+	KeepaliveTimeout  time.Duration `json:"-"` // 0 表示使用 DefaultKeepaliveTimeout
 }
 
 var DefaultOption = &Option{
 	MagicNumber:    MagicNumber,
 	CodecType:      codec.GobType,
+	Version:        codec.FrameVersion,
 	ConnectTimeout: time.Second * 10,
 }
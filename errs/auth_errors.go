@@ -0,0 +1,8 @@
+package errs
+
+import "errors"
+
+var (
+	ErrUnauthenticated  = errors.New("rpc server: unauthenticated")
+	ErrPermissionDenied = errors.New("rpc server: permission denied")
+)
@@ -7,5 +7,7 @@ var (
 	ErrOptionsEmpty = errors.New("number of options is more than 1")
 	ErrClientConnectTimeout = errors.New("rpc client: connect timeout")
 	ErrClientCallTimeout = errors.New("rpc client: call timeout")
+	ErrClientCallCanceled = errors.New("rpc client: call canceled")
 	ErrUnexpextedHTTPResponse = errors.New("unexpected HTTP response")
+	ErrClientDisconnected = errors.New("rpc client: disconnected, reconnecting")
 )
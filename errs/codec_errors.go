@@ -0,0 +1,10 @@
+package errs
+
+import "errors"
+
+var (
+	ErrInvalidFrameMagic       = errors.New("rpc codec: invalid frame magic")
+	ErrUnsupportedFrameVersion = errors.New("rpc codec: unsupported frame version")
+	ErrFrameTooLarge           = errors.New("rpc codec: frame exceeds max message size")
+	ErrMalformedHeader         = errors.New("rpc codec: malformed message header")
+)
@@ -0,0 +1,10 @@
+package errs
+
+import "errors"
+
+var (
+	ErrInvalidMagicNumber = errors.New("rpc handshake: invalid magic number")
+	ErrUnsupportedCodec   = errors.New("rpc handshake: unsupported codec type")
+	ErrUnsupportedVersion = errors.New("rpc handshake: unsupported protocol version")
+	ErrHandshakeRejected  = errors.New("rpc handshake: rejected by server")
+)
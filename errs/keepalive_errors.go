@@ -0,0 +1,7 @@
+package errs
+
+import "errors"
+
+var (
+	ErrKeepaliveTimeout = errors.New("rpc: keepalive timeout, connection closed")
+)
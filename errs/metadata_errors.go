@@ -0,0 +1,7 @@
+package errs
+
+import "errors"
+
+var (
+	ErrNoTrailer = errors.New("rpc metadata: no trailer registered in context")
+)
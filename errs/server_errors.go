@@ -7,4 +7,5 @@ var (
 	ErrServiceIllFormed = errors.New("rpc server: service/method request ill-formed")
 	ErrServiceNotFound = errors.New("rpc server: can't find service")
 	ErrServiceHandleTimeout = errors.New("rpc server: request handle timeout")
+	ErrServerShutdown = errors.New("rpc server: server is shutting down")
 )
\ No newline at end of file
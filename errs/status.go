@@ -0,0 +1,159 @@
+package errs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Code 是随响应返回给客户端的错误码
+type Code uint32
+
+const (
+	CodeOK Code = iota
+	CodeCanceled
+	CodeUnknown
+	CodeInvalidArgument
+	CodeDeadlineExceeded
+	CodeNotFound
+	CodeAlreadyExists
+	CodePermissionDenied
+	CodeResourceExhausted
+	CodeFailedPrecondition
+	CodeAborted
+	CodeUnimplemented
+	CodeInternal
+	CodeUnavailable
+	CodeUnauthenticated
+)
+
+var codeNames = [...]string{
+	CodeOK:                 "OK",
+	CodeCanceled:           "Canceled",
+	CodeUnknown:            "Unknown",
+	CodeInvalidArgument:    "InvalidArgument",
+	CodeDeadlineExceeded:   "DeadlineExceeded",
+	CodeNotFound:           "NotFound",
+	CodeAlreadyExists:      "AlreadyExists",
+	CodePermissionDenied:   "PermissionDenied",
+	CodeResourceExhausted:  "ResourceExhausted",
+	CodeFailedPrecondition: "FailedPrecondition",
+	CodeAborted:            "Aborted",
+	CodeUnimplemented:      "Unimplemented",
+	CodeInternal:           "Internal",
+	CodeUnavailable:        "Unavailable",
+	CodeUnauthenticated:    "Unauthenticated",
+}
+
+func (c Code) String() string {
+	if int(c) < len(codeNames) {
+		return codeNames[c]
+	}
+	return fmt.Sprintf("Code(%d)", uint32(c))
+}
+
+// sentinelCodes 记录 errs 包中预定义错误对应的错误码
+var sentinelCodes = map[error]Code{
+	ErrShutdown:                CodeUnavailable,
+	ErrClientDisconnected:      CodeUnavailable,
+	ErrKeepaliveTimeout:        CodeUnavailable,
+	ErrClientConnectTimeout:    CodeDeadlineExceeded,
+	ErrClientCallTimeout:       CodeDeadlineExceeded,
+	ErrClientCallCanceled:      CodeCanceled,
+	ErrUnexpextedHTTPResponse:  CodeUnavailable,
+	ErrServiceAlreadyDefined:   CodeAlreadyExists,
+	ErrServiceIllFormed:        CodeInvalidArgument,
+	ErrServiceNotFound:         CodeNotFound,
+	ErrServiceHandleTimeout:    CodeDeadlineExceeded,
+	ErrServerShutdown:          CodeUnavailable,
+	ErrNoAvailableServers:      CodeUnavailable,
+	ErrNotSupportedSelectMode:  CodeInvalidArgument,
+	ErrInvalidFrameMagic:       CodeInvalidArgument,
+	ErrUnsupportedFrameVersion: CodeUnimplemented,
+	ErrFrameTooLarge:           CodeResourceExhausted,
+	ErrMalformedHeader:         CodeInvalidArgument,
+	ErrInvalidMagicNumber:      CodeInvalidArgument,
+	ErrUnsupportedCodec:        CodeUnimplemented,
+	ErrUnsupportedVersion:      CodeUnimplemented,
+	ErrHandshakeRejected:       CodeFailedPrecondition,
+	ErrUnauthenticated:         CodeUnauthenticated,
+	ErrPermissionDenied:        CodePermissionDenied,
+	ErrStreamUnsupported:       CodeUnimplemented,
+	ErrStreamMismatch:          CodeInvalidArgument,
+	ErrStreamWindowExceeded:    CodeResourceExhausted,
+	ErrStreamSendClosed:        CodeFailedPrecondition,
+}
+
+// Status 是带有错误码的错误，服务端返回的错误在客户端都会还原为 *Status
+type Status struct {
+	Code    Code
+	Message string
+	Details map[string]string // 可选的结构化信息
+}
+
+func NewStatus(code Code, message string) *Status {
+	return &Status{Code: code, Message: message}
+}
+
+// WithDetails 返回附带了 details 的副本
+func (s *Status) WithDetails(details map[string]string) *Status {
+	st := *s
+	st.Details = details
+	return &st
+}
+
+func (s *Status) Error() string {
+	return s.Message
+}
+
+// Is 使 errors.Is 可以用 errs 包中预定义的错误判断服务端返回的错误。错误码一致，并且信息与预定义错误相同
+// 或者以 ": " 加预定义错误的信息结尾（服务端以 fmt.Errorf("...: %w", err) 包装）时认为相同；
+// 以其他方式包装的预定义错误只保留错误码。target 为 *Status 时只比较错误码
+func (s *Status) Is(target error) bool {
+	if t, ok := target.(*Status); ok {
+		return s.Code == t.Code
+	}
+	code, ok := sentinelCodes[target]
+	if !ok || code != s.Code {
+		return false
+	}
+	msg := target.Error()
+	return s.Message == msg || strings.HasSuffix(s.Message, ": "+msg)
+}
+
+// FromError 将任意错误转换为 *Status，nil 对应 nil
+func FromError(err error) *Status {
+	if err == nil {
+		return nil
+	}
+	var st *Status
+	if errors.As(err, &st) {
+		return st
+	}
+	code := CodeOf(err)
+	return &Status{Code: code, Message: err.Error()}
+}
+
+// CodeOf 返回错误对应的错误码，无法识别的错误为 CodeUnknown
+func CodeOf(err error) Code {
+	if err == nil {
+		return CodeOK
+	}
+	var st *Status
+	if errors.As(err, &st) {
+		return st.Code
+	}
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		if code, ok := sentinelCodes[e]; ok {
+			return code
+		}
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return CodeDeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		return CodeCanceled
+	}
+	return CodeUnknown
+}
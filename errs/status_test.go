@@ -0,0 +1,36 @@
+package errs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatus_Is(t *testing.T) {
+	st := FromError(ErrServiceNotFound)
+	assert.Equal(t, CodeNotFound, st.Code)
+	assert.True(t, errors.Is(st, ErrServiceNotFound))
+	assert.False(t, errors.Is(st, ErrServiceIllFormed))
+	assert.True(t, errors.Is(st, NewStatus(CodeNotFound, "")))
+
+	// 错误码相同但信息不同时不认为是同一个预定义错误
+	assert.False(t, errors.Is(NewStatus(CodeDeadlineExceeded, "other"), ErrServiceHandleTimeout))
+
+	// 服务端以 %w 包装的预定义错误经过传输后仍然可以识别
+	st = FromError(fmt.Errorf("lookup user: %w", ErrServiceNotFound))
+	assert.Equal(t, CodeNotFound, st.Code)
+	assert.True(t, errors.Is(&Status{Code: st.Code, Message: st.Message}, ErrServiceNotFound))
+	assert.False(t, errors.Is(NewStatus(CodeNotFound, "lookup user"+ErrServiceNotFound.Error()), ErrServiceNotFound))
+}
+
+func TestCodeOf(t *testing.T) {
+	assert.Equal(t, CodeOK, CodeOf(nil))
+	assert.Equal(t, CodeDeadlineExceeded, CodeOf(fmt.Errorf("wrap: %w", ErrServiceHandleTimeout)))
+	assert.Equal(t, CodeCanceled, CodeOf(context.Canceled))
+	assert.Equal(t, CodePermissionDenied, CodeOf(fmt.Errorf("wrap: %w", NewStatus(CodePermissionDenied, "no"))))
+	assert.Equal(t, CodeUnknown, CodeOf(errors.New("boom")))
+	assert.Equal(t, "NotFound", CodeNotFound.String())
+}
@@ -0,0 +1,10 @@
+package errs
+
+import "errors"
+
+var (
+	ErrStreamUnsupported    = errors.New("rpc client: server does not support streaming")
+	ErrStreamMismatch       = errors.New("rpc server: method kind does not match the call, use Call for unary methods and NewStream for streaming methods")
+	ErrStreamWindowExceeded = errors.New("rpc client: stream flow control window exceeded")
+	ErrStreamSendClosed     = errors.New("rpc client: send on a stream after CloseSend")
+)
@@ -0,0 +1,108 @@
+package metadata
+
+import (
+	"context"
+
+	"github.com/qiancijun/minirpc/errs"
+)
+
+// MD 是随请求或响应一起发送的键值对，请求中为客户端附带的元数据，响应中为服务端设置的 trailer
+type MD map[string]string
+
+// Pairs 由 k1, v1, k2, v2... 形式的参数构造 MD，参数个数为奇数时忽略最后一个
+func Pairs(kv ...string) MD {
+	md := make(MD, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		md[kv[i]] = kv[i+1]
+	}
+	return md
+}
+
+func (md MD) Get(key string) string {
+	return md[key]
+}
+
+func (md MD) Set(key, value string) {
+	md[key] = value
+}
+
+func (md MD) Copy() MD {
+	out := make(MD, len(md))
+	for k, v := range md {
+		out[k] = v
+	}
+	return out
+}
+
+// Join 合并多个 MD，相同的键以后出现的为准
+func Join(mds ...MD) MD {
+	out := MD{}
+	for _, md := range mds {
+		for k, v := range md {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+type (
+	outgoingKey      struct{}
+	incomingKey      struct{}
+	trailerKey       struct{}
+	serverTrailerKey struct{}
+)
+
+// NewOutgoingContext 返回附带了 md 的 context，客户端会把它随请求发送给服务端
+func NewOutgoingContext(ctx context.Context, md MD) context.Context {
+	return context.WithValue(ctx, outgoingKey{}, md)
+}
+
+// AppendToOutgoingContext 在 ctx 已有的发送元数据上追加键值对
+func AppendToOutgoingContext(ctx context.Context, kv ...string) context.Context {
+	md, _ := FromOutgoingContext(ctx)
+	return NewOutgoingContext(ctx, Join(md, Pairs(kv...)))
+}
+
+func FromOutgoingContext(ctx context.Context) (MD, bool) {
+	md, ok := ctx.Value(outgoingKey{}).(MD)
+	return md, ok
+}
+
+// NewIncomingContext 由服务端调用，记录请求附带的元数据
+func NewIncomingContext(ctx context.Context, md MD) context.Context {
+	return context.WithValue(ctx, incomingKey{}, md)
+}
+
+// FromIncomingContext 返回服务端收到的请求元数据
+func FromIncomingContext(ctx context.Context) (MD, bool) {
+	md, ok := ctx.Value(incomingKey{}).(MD)
+	return md, ok
+}
+
+// WithTrailer 由客户端调用，登记接收服务端返回的 trailer 的位置
+func WithTrailer(ctx context.Context, trailer *MD) context.Context {
+	return context.WithValue(ctx, trailerKey{}, trailer)
+}
+
+// DeliverTrailer 由客户端调用，将收到的 trailer 合并到调用方通过 WithTrailer 登记的位置，没有登记时忽略
+func DeliverTrailer(ctx context.Context, md MD) {
+	if trailer, ok := ctx.Value(trailerKey{}).(*MD); ok {
+		*trailer = Join(*trailer, md)
+	}
+}
+
+// NewServerTrailerContext 由服务端调用，登记收集处理方法设置的 trailer 的位置。
+// 与 WithTrailer 使用不同的键，处理方法用收到的 ctx 发起下游调用时，下游返回的 trailer 不会混入自己的响应
+func NewServerTrailerContext(ctx context.Context, trailer *MD) context.Context {
+	return context.WithValue(ctx, serverTrailerKey{}, trailer)
+}
+
+// SetTrailer 由服务端的处理方法调用，将 md 合并到随响应返回的 trailer 中，不能并发调用
+func SetTrailer(ctx context.Context, md MD) error {
+	trailer, ok := ctx.Value(serverTrailerKey{}).(*MD)
+	if !ok {
+		return errs.ErrNoTrailer
+	}
+	*trailer = Join(*trailer, md)
+	return nil
+}
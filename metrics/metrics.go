@@ -0,0 +1,330 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefBuckets 是延迟直方图的默认分桶，单位为秒
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// DefaultRegistry 是服务端与客户端未指定时使用的注册表
+var DefaultRegistry = NewRegistry()
+
+const (
+	counterType   = "counter"
+	gaugeType     = "gauge"
+	histogramType = "histogram"
+)
+
+// Registry 保存所有指标，并以 Prometheus 文本格式输出
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+type metric interface {
+	write(w *bufio.Writer, name, labels string)
+}
+
+// family 是同名指标在不同标签取值下的集合
+type family struct {
+	name, help, typ string
+	labels          []string
+	newMetric       func() metric
+
+	mu     sync.RWMutex
+	series map[string]*series
+}
+
+type series struct {
+	labels string // 已经格式化的标签，例如 method="Foo.Sum"
+	m      metric
+}
+
+// family 返回名为 name 的指标族，不存在时创建；同名指标的类型或标签不一致属于编程错误
+func (r *Registry) family(name, help, typ string, labels []string, newMetric func() metric) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if f, ok := r.families[name]; ok {
+		if f.typ != typ || strings.Join(f.labels, ",") != strings.Join(labels, ",") {
+			panic(fmt.Sprintf("metrics: %s already registered with a different type or labels", name))
+		}
+		return f
+	}
+	f := &family{
+		name:      name,
+		help:      help,
+		typ:       typ,
+		labels:    labels,
+		newMetric: newMetric,
+		series:    make(map[string]*series),
+	}
+	r.families[name] = f
+	return f
+}
+
+func (f *family) with(values []string) metric {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	f.mu.RLock()
+	s, ok := f.series[key]
+	f.mu.RUnlock()
+	if ok {
+		return s.m
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s, ok := f.series[key]; ok {
+		return s.m
+	}
+	s = &series{labels: formatLabels(f.labels, values), m: f.newMetric()}
+	f.series[key] = s
+	return s.m
+}
+
+func (f *family) write(w *bufio.Writer) {
+	f.mu.RLock()
+	list := make([]*series, 0, len(f.series))
+	for _, s := range f.series {
+		list = append(list, s)
+	}
+	f.mu.RUnlock()
+	if len(list) == 0 {
+		return
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].labels < list[j].labels })
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.typ)
+	for _, s := range list {
+		s.m.write(w, f.name, s.labels)
+	}
+}
+
+// Write 以 Prometheus 文本格式输出所有指标，指标族按名称排序
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	list := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		list = append(list, f)
+	}
+	r.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+
+	bw := bufio.NewWriter(w)
+	for _, f := range list {
+		f.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler 返回输出 r 中所有指标的 HTTP 处理器，服务端可以通过 Server.HandleMetrics 挂载，例如
+// http.Handle(common.DefaultMetricsPath, metrics.Handler(metrics.DefaultRegistry))
+func Handler(r *Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = r.Write(w)
+	})
+}
+
+type CounterVec struct{ f *family }
+
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{r.family(name, help, counterType, labels, func() metric { return new(Counter) })}
+}
+
+// With 返回标签取值为 values 的计数器，values 的顺序与注册时的标签一致
+func (v *CounterVec) With(values ...string) *Counter {
+	return v.f.with(values).(*Counter)
+}
+
+// Counter 是只增不减的计数器
+type Counter struct{ v atomicFloat }
+
+func (c *Counter) Inc()              { c.v.add(1) }
+func (c *Counter) Add(delta float64) { c.v.add(delta) }
+func (c *Counter) Value() float64    { return c.v.load() }
+
+func (c *Counter) write(w *bufio.Writer, name, labels string) {
+	writeSample(w, name, labels, c.Value())
+}
+
+type GaugeVec struct{ f *family }
+
+func (r *Registry) Gauge(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{r.family(name, help, gaugeType, labels, func() metric { return new(Gauge) })}
+}
+
+func (v *GaugeVec) With(values ...string) *Gauge {
+	return v.f.with(values).(*Gauge)
+}
+
+// Gauge 是可增可减的瞬时值
+type Gauge struct{ v atomicFloat }
+
+func (g *Gauge) Set(value float64) { g.v.store(value) }
+func (g *Gauge) Add(delta float64) { g.v.add(delta) }
+func (g *Gauge) Inc()              { g.v.add(1) }
+func (g *Gauge) Dec()              { g.v.add(-1) }
+func (g *Gauge) Value() float64    { return g.v.load() }
+
+func (g *Gauge) write(w *bufio.Writer, name, labels string) {
+	writeSample(w, name, labels, g.Value())
+}
+
+type HistogramVec struct{ f *family }
+
+// Histogram 注册直方图，buckets 为各分桶的上界，需要升序排列，为空时使用 DefBuckets
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefBuckets
+	}
+	return &HistogramVec{r.family(name, help, histogramType, labels, func() metric {
+		return &Histogram{upper: buckets, counts: make([]uint64, len(buckets))}
+	})}
+}
+
+func (v *HistogramVec) With(values ...string) *Histogram {
+	return v.f.with(values).(*Histogram)
+}
+
+// Histogram 统计观测值的分布
+type Histogram struct {
+	mu     sync.Mutex
+	upper  []float64
+	counts []uint64 // 落在各分桶中的数量，输出时再累加
+	count  uint64
+	sum    float64
+}
+
+func (h *Histogram) Observe(value float64) {
+	i := sort.SearchFloat64s(h.upper, value)
+	h.mu.Lock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += value
+	h.mu.Unlock()
+}
+
+func (h *Histogram) Count() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+func (h *Histogram) Sum() float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.sum
+}
+
+func (h *Histogram) write(w *bufio.Writer, name, labels string) {
+	h.mu.Lock()
+	counts := append([]uint64(nil), h.counts...)
+	count, sum := h.count, h.sum
+	h.mu.Unlock()
+
+	var cumulative uint64
+	for i, upper := range h.upper {
+		cumulative += counts[i]
+		writeSample(w, name+"_bucket", joinLabels(labels, `le="`+formatFloat(upper)+`"`), float64(cumulative))
+	}
+	writeSample(w, name+"_bucket", joinLabels(labels, `le="+Inf"`), float64(count))
+	writeSample(w, name+"_sum", labels, sum)
+	writeSample(w, name+"_count", labels, float64(count))
+}
+
+type atomicFloat struct{ bits atomic.Uint64 }
+
+func (f *atomicFloat) load() float64 { return math.Float64frombits(f.bits.Load()) }
+
+func (f *atomicFloat) store(v float64) { f.bits.Store(math.Float64bits(v)) }
+
+func (f *atomicFloat) add(delta float64) {
+	for {
+		old := f.bits.Load()
+		if f.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+func writeSample(w *bufio.Writer, name, labels string, value float64) {
+	w.WriteString(name)
+	if labels != "" {
+		w.WriteString("{" + labels + "}")
+	}
+	w.WriteString(" " + formatFloat(value) + "\n")
+}
+
+func formatLabels(names, values []string) string {
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + escapeLabel(values[i]) + `"`
+	}
+	return strings.Join(pairs, ",")
+}
+
+func joinLabels(labels, extra string) string {
+	if labels == "" {
+		return extra
+	}
+	return labels + "," + extra
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+func escapeHelp(s string) string { return helpEscaper.Replace(s) }
+
+type countingConn struct {
+	io.ReadWriteCloser
+	in, out *Counter
+}
+
+// CountConn 包装 conn，将读取与写入的字节数分别累加到 in 与 out
+func CountConn(conn io.ReadWriteCloser, in, out *Counter) io.ReadWriteCloser {
+	return &countingConn{ReadWriteCloser: conn, in: in, out: out}
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(p)
+	c.in.Add(float64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Write(p)
+	c.out.Add(float64(n))
+	return n, err
+}
@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/qiancijun/minirpc/errs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry_Write(t *testing.T) {
+	r := NewRegistry()
+	calls := r.Counter("rpc_calls_total", "Total calls.", "method")
+	calls.With("Foo.Sum").Add(2)
+	calls.With(`Foo."Quoted"`).Inc()
+	r.Gauge("rpc_connections", "Open connections.").With().Set(3)
+	h := r.Histogram("rpc_seconds", "Latency.", []float64{0.1, 1}, "method").With("Foo.Sum")
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(5)
+	// 没有任何取值的指标不输出
+	r.Counter("rpc_unused_total", "Unused.", "method")
+
+	var buf bytes.Buffer
+	require.NoError(t, r.Write(&buf))
+	expect := `# HELP rpc_calls_total Total calls.
+# TYPE rpc_calls_total counter
+rpc_calls_total{method="Foo.Sum"} 2
+rpc_calls_total{method="Foo.\"Quoted\""} 1
+# HELP rpc_connections Open connections.
+# TYPE rpc_connections gauge
+rpc_connections 3
+# HELP rpc_seconds Latency.
+# TYPE rpc_seconds histogram
+rpc_seconds_bucket{method="Foo.Sum",le="0.1"} 1
+rpc_seconds_bucket{method="Foo.Sum",le="1"} 2
+rpc_seconds_bucket{method="Foo.Sum",le="+Inf"} 3
+rpc_seconds_sum{method="Foo.Sum"} 5.55
+rpc_seconds_count{method="Foo.Sum"} 3
+`
+	assert.Equal(t, expect, buf.String())
+}
+
+func TestRegistry_Reuse(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("c_total", "c", "a").With("x").Inc()
+	// 同名同类型的指标返回已有的指标族
+	assert.Equal(t, float64(1), r.Counter("c_total", "c", "a").With("x").Value())
+	assert.Panics(t, func() { r.Gauge("c_total", "c", "a") })
+	assert.Panics(t, func() { r.Counter("c_total", "c", "a").With("x", "y") })
+}
+
+func TestCounter_Concurrent(t *testing.T) {
+	c := NewRegistry().Counter("c_total", "c").With()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				c.Inc()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, float64(10000), c.Value())
+}
+
+func TestRPC(t *testing.T) {
+	r := NewRegistry()
+	m := NewRPC(r, "server")
+	m.Begin("Foo.Sum")(nil)
+	m.Begin("Foo.Sum")(errs.ErrServiceHandleTimeout)
+	m.Begin("Foo.Sum")(errors.New("boom"))
+
+	rec := httptest.NewRecorder()
+	Handler(r).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.True(t, strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain; version=0.0.4"))
+	body := rec.Body.String()
+	assert.Contains(t, body, `minirpc_server_calls_total{method="Foo.Sum"} 3`)
+	assert.Contains(t, body, `minirpc_server_errors_total{method="Foo.Sum",code="DeadlineExceeded"} 1`)
+	assert.Contains(t, body, `minirpc_server_errors_total{method="Foo.Sum",code="Unknown"} 1`)
+	assert.Contains(t, body, `minirpc_server_timeouts_total{method="Foo.Sum"} 1`)
+	assert.Contains(t, body, `minirpc_server_in_flight{method="Foo.Sum"} 0`)
+	assert.Contains(t, body, `minirpc_server_call_duration_seconds_count{method="Foo.Sum"} 3`)
+}
@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/qiancijun/minirpc/errs"
+)
+
+// RPC 是服务端或客户端一侧的调用指标，同一注册表中同一侧的实例共享指标
+type RPC struct {
+	calls       *CounterVec
+	oneWay      *CounterVec
+	attempts    *HistogramVec
+	throttled   *CounterVec
+	errors      *CounterVec
+	timeouts    *CounterVec
+	duration    *HistogramVec
+	inFlight    *GaugeVec
+	received    *Counter
+	sent        *Counter
+	connections *Gauge
+}
+
+// NewRPC 在 r 中注册 side 一侧的调用指标，side 为 "server" 或 "client"，r 为 nil 时使用 DefaultRegistry
+func NewRPC(r *Registry, side string) *RPC {
+	if r == nil {
+		r = DefaultRegistry
+	}
+	prefix := "minirpc_" + side + "_"
+	return &RPC{
+		calls:       r.Counter(prefix+"calls_total", "Total number of calls.", "method"),
+		oneWay:      r.Counter(prefix+"oneway_calls_total", "Total number of one-way calls, also counted in calls_total.", "method"),
+		attempts:    r.Histogram(prefix+"call_attempts", "Number of attempts made by calls that may be retried.", []float64{1, 2, 3, 4, 5}, "method"),
+		throttled:   r.Counter(prefix+"retries_throttled_total", "Total number of retries skipped by the retry throttle.", "method"),
+		errors:      r.Counter(prefix+"errors_total", "Total number of failed calls by status code.", "method", "code"),
+		timeouts:    r.Counter(prefix+"timeouts_total", "Total number of calls that exceeded their deadline.", "method"),
+		duration:    r.Histogram(prefix+"call_duration_seconds", "Latency of calls in seconds.", nil, "method"),
+		inFlight:    r.Gauge(prefix+"in_flight", "Number of calls currently in flight.", "method"),
+		received:    r.Counter(prefix+"received_bytes_total", "Total number of bytes read from connections.").With(),
+		sent:        r.Counter(prefix+"sent_bytes_total", "Total number of bytes written to connections.").With(),
+		connections: r.Gauge(prefix+"connections", "Number of open connections.").With(),
+	}
+}
+
+// Begin 记录一次调用的开始，调用结束时以调用结果执行返回的函数
+func (m *RPC) Begin(method string) func(err error) {
+	start := time.Now()
+	inFlight := m.inFlight.With(method)
+	inFlight.Inc()
+	return func(err error) {
+		inFlight.Dec()
+		m.calls.With(method).Inc()
+		m.duration.With(method).Observe(time.Since(start).Seconds())
+		if err == nil {
+			return
+		}
+		code := errs.CodeOf(err)
+		m.errors.With(method, code.String()).Inc()
+		if code == errs.CodeDeadlineExceeded {
+			m.timeouts.With(method).Inc()
+		}
+	}
+}
+
+// OneWay 记录一次单向调用，调用本身仍然通过 Begin 记录
+func (m *RPC) OneWay(method string) {
+	m.oneWay.With(method).Inc()
+}
+
+// Attempts 记录一次带有重试策略的调用最终的尝试次数，每次尝试仍然通过 Begin 记录
+func (m *RPC) Attempts(method string, n int) {
+	m.attempts.With(method).Observe(float64(n))
+}
+
+// RetryThrottled 记录一次因为重试预算不足而放弃的重试
+func (m *RPC) RetryThrottled(method string) {
+	m.throttled.With(method).Inc()
+}
+
+// Conn 统计 conn 的读写字节数并计入连接数，连接关闭时通过返回值的 Close 扣减
+func (m *RPC) Conn(conn io.ReadWriteCloser) io.ReadWriteCloser {
+	m.connections.Inc()
+	return &trackedConn{ReadWriteCloser: CountConn(conn, m.received, m.sent), connections: m.connections}
+}
+
+type trackedConn struct {
+	io.ReadWriteCloser
+	connections *Gauge
+	once        sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(c.connections.Dec)
+	return c.ReadWriteCloser.Close()
+}
@@ -1,8 +1,10 @@
 package registry
 
 import (
+	"fmt"
 	"net/http"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -17,8 +19,9 @@ type MiniRegister struct {
 }
 
 type ServerItem struct {
-	Addr  string
-	start time.Time
+	Addr   string
+	Weight int // 负载均衡权重，心跳没有携带时为 1
+	start  time.Time
 }
 
 var (
@@ -32,21 +35,24 @@ func NewRegistry(timeout time.Duration) *MiniRegister {
 	}
 }
 
-func (r *MiniRegister) putServer(addr string) {
+func (r *MiniRegister) putServer(addr string, weight int) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	s := r.servers[addr]
 	if s == nil {
 		r.servers[addr] = &ServerItem{
-			Addr:  addr,
-			start: time.Now(),
+			Addr:   addr,
+			Weight: weight,
+			start:  time.Now(),
 		}
 	} else {
+		s.Weight = weight
 		s.start = time.Now()
 	}
 }
 
-func (r *MiniRegister) aliveServers() []string {
+// aliveServers 返回按地址排序的存活服务，以及对应的 addr=weight 列表
+func (r *MiniRegister) aliveServers() ([]string, []string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	var alive []string
@@ -58,20 +64,35 @@ func (r *MiniRegister) aliveServers() []string {
 		}
 	}
 	sort.Strings(alive)
-	return alive
+	weights := make([]string, len(alive))
+	for i, addr := range alive {
+		weights[i] = fmt.Sprintf("%s=%d", addr, r.servers[addr].Weight)
+	}
+	return alive, weights
 }
 
 func (r *MiniRegister) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case "GET":
-		w.Header().Set("X-Minirpc-Servers", strings.Join(r.aliveServers(), ","))
+		alive, weights := r.aliveServers()
+		w.Header().Set("X-Minirpc-Servers", strings.Join(alive, ","))
+		w.Header().Set("X-Minirpc-Weights", strings.Join(weights, ","))
 	case "POST":
 		addr := req.Header.Get("X-Minirpc-Server")
 		if addr == "" {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		r.putServer(addr)
+		weight := 1
+		if v := req.Header.Get("X-Minirpc-Weight"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			weight = n
+		}
+		r.putServer(addr, weight)
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
@@ -86,24 +107,32 @@ func HandleHTTP() {
 }
 
 func HeartBeat(registry, addr string, duration time.Duration) {
+	HeartBeatWithWeight(registry, addr, 0, duration)
+}
+
+// HeartBeatWithWeight 与 HeartBeat 相同，同时上报服务的负载均衡权重，weight 为 0 时不上报
+func HeartBeatWithWeight(registry, addr string, weight int, duration time.Duration) {
 	if duration == 0 {
 		duration = common.DefaultTimeout - time.Duration(1) * time.Minute
 	}
 	var err error 
-	err = sendHeartBeat(registry, addr)
+	err = sendHeartBeat(registry, addr, weight)
 	go func() {
 		t := time.NewTicker(duration)
 		for err == nil {
 			<- t.C
-			err = sendHeartBeat(registry, addr)
+			err = sendHeartBeat(registry, addr, weight)
 		}
 	}()
 }
 
-func sendHeartBeat(registry, addr string) error {
+func sendHeartBeat(registry, addr string, weight int) error {
 	httpClient := &http.Client{}
 	req, _ := http.NewRequest("POST", registry, nil)
 	req.Header.Set("X-Minirpc-Server", addr)
+	if weight > 0 {
+		req.Header.Set("X-Minirpc-Weight", strconv.Itoa(weight))
+	}
 	if _, err := httpClient.Do(req); err != nil {
 		return err
 	}
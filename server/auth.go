@@ -0,0 +1,177 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/qiancijun/minirpc/common"
+	"github.com/qiancijun/minirpc/errs"
+	"github.com/qiancijun/minirpc/metadata"
+)
+
+type principalKey struct{}
+
+// Principal 是通过认证的调用方
+type Principal struct {
+	Name       string
+	Attributes map[string]string // 认证器附加的信息，例如租户、角色
+}
+
+// Credentials 是客户端提交的凭证
+type Credentials struct {
+	Token string // 握手时的 Option.Token 或请求元数据中 common.AuthorizationKey 对应的值
+	Peer  *Peer  // 连接信息，双向 TLS 下可以用 Peer.Identity 认证
+}
+
+// Authenticator 校验凭证并返回调用方，校验失败时返回错误
+type Authenticator interface {
+	Authenticate(ctx context.Context, creds *Credentials) (*Principal, error)
+}
+
+type AuthenticatorFunc func(ctx context.Context, creds *Credentials) (*Principal, error)
+
+func (f AuthenticatorFunc) Authenticate(ctx context.Context, creds *Credentials) (*Principal, error) {
+	return f(ctx, creds)
+}
+
+// TokenAuthenticator 是基于静态 token 表的认证器，键为 token，值为调用方名称
+type TokenAuthenticator map[string]string
+
+func (a TokenAuthenticator) Authenticate(_ context.Context, creds *Credentials) (*Principal, error) {
+	name, ok := a[creds.Token]
+	if !ok || creds.Token == "" {
+		return nil, errs.ErrUnauthenticated
+	}
+	return &Principal{Name: name}, nil
+}
+
+// Authorizer 判断调用方能否调用 serviceMethod，principal 为 nil 表示匿名调用
+type Authorizer interface {
+	Authorize(ctx context.Context, principal *Principal, serviceMethod string) error
+}
+
+type AuthorizerFunc func(ctx context.Context, principal *Principal, serviceMethod string) error
+
+func (f AuthorizerFunc) Authorize(ctx context.Context, principal *Principal, serviceMethod string) error {
+	return f(ctx, principal, serviceMethod)
+}
+
+type policyRule struct {
+	allow         bool
+	principal     string
+	serviceMethod string
+}
+
+// Policy 按调用方与 Service.Method 配置允许/拒绝规则，拒绝规则优先，都不匹配时使用默认值。
+// principal 为 "*" 时匹配任意调用方（包括匿名调用），serviceMethod 可以是 "*"、"Service.*" 或完整的方法名。
+// 规则需要在开始处理连接前配置完成
+type Policy struct {
+	rules        []policyRule
+	defaultAllow bool
+}
+
+func NewPolicy(defaultAllow bool) *Policy {
+	return &Policy{defaultAllow: defaultAllow}
+}
+
+func (p *Policy) Allow(principal, serviceMethod string) *Policy {
+	p.rules = append(p.rules, policyRule{allow: true, principal: principal, serviceMethod: serviceMethod})
+	return p
+}
+
+func (p *Policy) Deny(principal, serviceMethod string) *Policy {
+	p.rules = append(p.rules, policyRule{allow: false, principal: principal, serviceMethod: serviceMethod})
+	return p
+}
+
+func (p *Policy) Authorize(_ context.Context, principal *Principal, serviceMethod string) error {
+	name := ""
+	if principal != nil {
+		name = principal.Name
+	}
+	allowed := p.defaultAllow
+	for _, r := range p.rules {
+		if !r.match(name, serviceMethod) {
+			continue
+		}
+		if !r.allow {
+			return errs.ErrPermissionDenied
+		}
+		allowed = true
+	}
+	if !allowed {
+		return errs.ErrPermissionDenied
+	}
+	return nil
+}
+
+func (r *policyRule) match(principal, serviceMethod string) bool {
+	if r.principal != "*" && r.principal != principal {
+		return false
+	}
+	switch {
+	case r.serviceMethod == "*":
+		return true
+	case strings.HasSuffix(r.serviceMethod, ".*"):
+		return strings.HasPrefix(serviceMethod, r.serviceMethod[:len(r.serviceMethod)-1])
+	default:
+		return r.serviceMethod == serviceMethod
+	}
+}
+
+// PrincipalFromContext 返回请求 context 中通过认证的调用方，未配置认证器时不存在
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok && p != nil
+}
+
+// authenticateConn 校验握手时提交的凭证，没有提交凭证时以匿名身份建立连接，
+// 之后的请求需要在元数据中携带凭证
+func authenticateConn(ctx context.Context, a Authenticator, token string) (context.Context, error) {
+	if a == nil {
+		return ctx, nil
+	}
+	peer, _ := PeerFromContext(ctx)
+	p, err := a.Authenticate(ctx, &Credentials{Token: token, Peer: peer})
+	if err != nil {
+		if token != "" {
+			return ctx, err
+		}
+		return ctx, nil
+	}
+	return context.WithValue(ctx, principalKey{}, p), nil
+}
+
+// authorize 在调用服务方法前完成认证与鉴权，返回带有调用方信息的 context
+func (sc *serverConn) authorize(ctx context.Context, serviceMethod string) (context.Context, error) {
+	if sc.authenticator != nil {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if token := md.Get(common.AuthorizationKey); token != "" {
+			peer, _ := PeerFromContext(ctx)
+			p, err := sc.authenticator.Authenticate(ctx, &Credentials{Token: token, Peer: peer})
+			if err != nil {
+				return ctx, authError(errs.ErrUnauthenticated, errs.CodeUnauthenticated, err)
+			}
+			ctx = context.WithValue(ctx, principalKey{}, p)
+		} else if _, ok := PrincipalFromContext(ctx); !ok {
+			return ctx, errs.ErrUnauthenticated
+		}
+	}
+	if sc.authorizer != nil {
+		p, _ := PrincipalFromContext(ctx)
+		if err := sc.authorizer.Authorize(ctx, p, serviceMethod); err != nil {
+			return ctx, authError(errs.ErrPermissionDenied, errs.CodePermissionDenied, err)
+		}
+	}
+	return ctx, nil
+}
+
+// authError 统一认证与鉴权失败的错误，保留原因供客户端排查
+func authError(sentinel error, code errs.Code, err error) error {
+	var st *errs.Status
+	if errors.Is(err, sentinel) || errors.As(err, &st) {
+		return err
+	}
+	return errs.NewStatus(code, sentinel.Error()).WithDetails(map[string]string{"reason": err.Error()})
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/qiancijun/minirpc/client"
+	"github.com/qiancijun/minirpc/common"
+	"github.com/qiancijun/minirpc/errs"
+	"github.com/qiancijun/minirpc/metadata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Whoami 返回通过认证的调用方名称
+func (f Foo) Whoami(ctx context.Context, args Args, reply *string) error {
+	p, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return errors.New("missing principal")
+	}
+	*reply = p.Name
+	return nil
+}
+
+func dialWithToken(t *testing.T, addr, token string) (*client.Client, error) {
+	opt := *common.DefaultOption
+	opt.Token = token
+	return client.Dial("tcp", addr, &opt)
+}
+
+func TestPolicy(t *testing.T) {
+	p := NewPolicy(false).
+		Allow("admin", "*").
+		Allow("*", "Foo.Sum").
+		Allow("alice", "Foo.*").
+		Deny("alice", "Foo.Panic")
+
+	cases := []struct {
+		principal     *Principal
+		serviceMethod string
+		allowed       bool
+	}{
+		{&Principal{Name: "admin"}, "Foo.Panic", true},
+		{nil, "Foo.Sum", true},
+		{nil, "Foo.Sleep", false},
+		{&Principal{Name: "alice"}, "Foo.Sleep", true},
+		{&Principal{Name: "alice"}, "Foo.Panic", false},
+		{&Principal{Name: "alice"}, "Bar.Sleep", false},
+		{&Principal{Name: "bob"}, "Foo.Whoami", false},
+	}
+	for _, c := range cases {
+		err := p.Authorize(context.Background(), c.principal, c.serviceMethod)
+		if c.allowed {
+			assert.NoError(t, err, "%v %s", c.principal, c.serviceMethod)
+		} else {
+			assert.ErrorIs(t, err, errs.ErrPermissionDenied, "%v %s", c.principal, c.serviceMethod)
+		}
+	}
+	assert.NoError(t, NewPolicy(true).Authorize(context.Background(), nil, "Foo.Sum"))
+}
+
+func TestServer_Auth(t *testing.T) {
+	t.Parallel()
+	_, addr := startTestServer(t, ServerOption{
+		Authenticator: TokenAuthenticator{"alice-token": "alice", "bob-token": "bob"},
+		Authorizer:    NewPolicy(false).Allow("alice", "Foo.*").Allow("bob", "Foo.Whoami"),
+	})
+	ctx := context.Background()
+
+	t.Run("handshake token", func(t *testing.T) {
+		c, err := dialWithToken(t, addr, "alice-token")
+		require.NoError(t, err)
+		defer func() { _ = c.Close() }()
+		var name string
+		require.NoError(t, c.Call(ctx, "Foo.Whoami", Args{}, &name))
+		assert.Equal(t, "alice", name)
+	})
+
+	t.Run("invalid handshake token", func(t *testing.T) {
+		_, err := dialWithToken(t, addr, "mallory-token")
+		assert.ErrorIs(t, err, errs.ErrUnauthenticated)
+	})
+
+	t.Run("per-call token", func(t *testing.T) {
+		c, err := dialWithToken(t, addr, "")
+		require.NoError(t, err)
+		defer func() { _ = c.Close() }()
+
+		var reply int
+		err = c.Call(ctx, "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		assert.ErrorIs(t, err, errs.ErrUnauthenticated)
+		assert.Equal(t, errs.CodeUnauthenticated, errs.CodeOf(err))
+
+		// 单次调用的凭证优先于握手时的凭证
+		var name string
+		callCtx := metadata.AppendToOutgoingContext(ctx, common.AuthorizationKey, "bob-token")
+		require.NoError(t, c.Call(callCtx, "Foo.Whoami", Args{}, &name))
+		assert.Equal(t, "bob", name)
+
+		callCtx = metadata.AppendToOutgoingContext(ctx, common.AuthorizationKey, "mallory-token")
+		err = c.Call(callCtx, "Foo.Whoami", Args{}, &name)
+		assert.ErrorIs(t, err, errs.ErrUnauthenticated)
+	})
+
+	t.Run("permission denied", func(t *testing.T) {
+		c, err := dialWithToken(t, addr, "bob-token")
+		require.NoError(t, err)
+		defer func() { _ = c.Close() }()
+
+		var reply int
+		err = c.Call(ctx, "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		assert.ErrorIs(t, err, errs.ErrPermissionDenied)
+		assert.Equal(t, errs.CodePermissionDenied, errs.CodeOf(err))
+		// 连接在鉴权失败后仍然可用
+		var name string
+		require.NoError(t, c.Call(ctx, "Foo.Whoami", Args{}, &name))
+		assert.Equal(t, "bob", name)
+	})
+}
+
+func TestServer_HTTPAuth(t *testing.T) {
+	t.Parallel()
+	s := NewServer()
+	require.NoError(t, s.Register(new(Foo)))
+	s.SetHTTPOption(ServerOption{Authenticator: TokenAuthenticator{"alice-token": "alice"}})
+	hs := httptest.NewServer(s)
+	defer hs.Close()
+	addr := hs.Listener.Addr().String()
+
+	// 经由 HTTP CONNECT 接入的连接同样需要认证
+	c, err := client.DialHTTP("tcp", addr)
+	require.NoError(t, err)
+	defer func() { _ = c.Close() }()
+	err = c.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, new(int))
+	assert.ErrorIs(t, err, errs.ErrUnauthenticated)
+
+	opt := *common.DefaultOption
+	opt.Token = "alice-token"
+	c2, err := client.DialHTTP("tcp", addr, &opt)
+	require.NoError(t, err)
+	defer func() { _ = c2.Close() }()
+	var name string
+	require.NoError(t, c2.Call(context.Background(), "Foo.Whoami", Args{}, &name))
+	assert.Equal(t, "alice", name)
+
+	// 要求 TLS 时拒绝明文的 CONNECT
+	s.SetHTTPOption(ServerOption{TLSConfig: &tls.Config{}})
+	_, err = client.DialHTTP("tcp", addr)
+	assert.Error(t, err)
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/qiancijun/minirpc/codec"
+	"github.com/qiancijun/minirpc/metrics"
+	"github.com/qiancijun/minirpc/trace"
+)
+
+// serverConn 保存单个连接上的状态
+type serverConn struct {
+	cc      codec.Codec
+	timeout time.Duration // 服务端对单个请求的处理时限，0 表示不限制
+	sending sync.Mutex
+
+	authenticator Authenticator
+	authorizer    Authorizer
+	metrics       *metrics.RPC
+	tracer        *trace.Tracer
+
+	wg         sync.WaitGroup
+	active     atomic.Int32 // 处理中的请求数
+	lastActive atomic.Int64 // 最近一次开始或结束处理请求的时间（UnixNano），用于关闭空闲连接
+	lastRead   atomic.Int64 // 最近一次收到消息的时间（UnixNano），心跳据此判断连接是否存活
+	goAwayAt   atomic.Int64 // 第一次发送 go away 的时间（UnixNano），0 表示还没有发送
+
+	ctx    context.Context // 连接断开时取消，所有请求的 context 都派生自它
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	calls   map[uint64]context.CancelFunc // 处理中的请求，用于响应客户端的取消消息
+	streams map[uint64]*serverStream      // 处理中的流，用于响应客户端的窗口更新
+}
+
+func newServerConn(ctx context.Context, cc codec.Codec, opts ServerOption) *serverConn {
+	ctx, cancel := context.WithCancel(ctx)
+	sc := &serverConn{
+		cc:            cc,
+		timeout:       opts.Timeout,
+		authenticator: opts.Authenticator,
+		authorizer:    opts.Authorizer,
+		metrics:       metrics.NewRPC(opts.Metrics, "server"),
+		tracer:        opts.Tracer,
+		ctx:           ctx,
+		cancel:        cancel,
+		calls:         make(map[uint64]context.CancelFunc),
+		streams:       make(map[uint64]*serverStream),
+	}
+	now := time.Now().UnixNano()
+	sc.lastActive.Store(now)
+	sc.lastRead.Store(now)
+	return sc
+}
+
+// newRequestContext 为请求创建 context，处理时限取服务端配置与客户端截止时间中较早的一个。
+// 流式调用可能持续很久，只受客户端的截止时间与取消限制
+func (sc *serverConn) newRequestContext(h *codec.Header, stream bool) context.Context {
+	timeout := sc.timeout
+	if stream {
+		timeout = 0
+	}
+	if h.Timeout > 0 && (timeout == 0 || time.Duration(h.Timeout) < timeout) {
+		timeout = time.Duration(h.Timeout)
+	}
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(sc.ctx, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(sc.ctx)
+	}
+	sc.mu.Lock()
+	sc.calls[h.Seq] = cancel
+	sc.mu.Unlock()
+	return ctx
+}
+
+// cancelCall 取消序列号为 seq 的请求，流的 StreamID 与发起请求的序列号相同，一并注销
+func (sc *serverConn) cancelCall(seq uint64) {
+	sc.mu.Lock()
+	cancel := sc.calls[seq]
+	delete(sc.calls, seq)
+	delete(sc.streams, seq)
+	sc.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// write 发送一条消息，处理请求的 goroutine 共用连接，需要串行写入
+func (sc *serverConn) write(h *codec.Header, body interface{}) error {
+	sc.sending.Lock()
+	defer sc.sending.Unlock()
+	return sc.cc.Write(h, body)
+}
@@ -0,0 +1,30 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+)
+
+type peerKey struct{}
+
+// Peer 描述发起请求的客户端
+type Peer struct {
+	Addr net.Addr
+	TLS  *tls.ConnectionState // 非 TLS 连接为 nil
+}
+
+// Identity 返回客户端证书的 Common Name，没有经过校验的客户端证书时返回空字符串
+func (p *Peer) Identity() string {
+	if p.TLS == nil || len(p.TLS.VerifiedChains) == 0 {
+		return ""
+	}
+	return p.TLS.VerifiedChains[0][0].Subject.CommonName
+}
+
+// PeerFromContext 返回请求 context 中记录的客户端信息，
+// 仅对以 context.Context 为第一个参数的服务方法有意义
+func PeerFromContext(ctx context.Context) (*Peer, bool) {
+	p, ok := ctx.Value(peerKey{}).(*Peer)
+	return p, ok
+}
@@ -0,0 +1,33 @@
+package server
+
+import "context"
+
+// UnaryServerInfo 描述被拦截的请求
+type UnaryServerInfo struct {
+	ServiceMethod string
+}
+
+// UnaryHandler 调用服务方法，由拦截器链的最内层执行
+type UnaryHandler func(ctx context.Context, args, reply interface{}) error
+
+// UnaryServerInterceptor 拦截一次服务端调用。
+// 拦截器通过 ctx 读取请求元数据（metadata.FromIncomingContext）和设置 trailer（metadata.SetTrailer），
+// 调用 handler 继续执行后续的拦截器与服务方法，并可以检查或替换返回的错误
+type UnaryServerInterceptor func(ctx context.Context, info *UnaryServerInfo, args, reply interface{}, handler UnaryHandler) error
+
+// ChainUnaryServer 将多个拦截器组合成一个，先传入的拦截器位于外层、先执行
+func ChainUnaryServer(interceptors ...UnaryServerInterceptor) UnaryServerInterceptor {
+	return func(ctx context.Context, info *UnaryServerInfo, args, reply interface{}, handler UnaryHandler) error {
+		return chainHandler(interceptors, info, handler)(ctx, args, reply)
+	}
+}
+
+func chainHandler(interceptors []UnaryServerInterceptor, info *UnaryServerInfo, handler UnaryHandler) UnaryHandler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], handler
+		handler = func(ctx context.Context, args, reply interface{}) error {
+			return interceptor(ctx, info, args, reply, next)
+		}
+	}
+	return handler
+}
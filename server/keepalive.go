@@ -0,0 +1,75 @@
+package server
+
+import (
+	"log"
+	"time"
+
+	"github.com/qiancijun/minirpc/codec"
+)
+
+// keepalive 在连接空闲 interval 后向客户端发送 ping，发送后 timeout 内没有收到任何消息就关闭连接，
+// 读循环随之退出并取消连接上所有处理中的请求
+func (sc *serverConn) keepalive(interval, timeout time.Duration) {
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-sc.ctx.Done():
+			return
+		}
+		if idle := time.Since(time.Unix(0, sc.lastRead.Load())); idle < interval {
+			timer.Reset(interval - idle)
+			continue
+		}
+		sent := time.Now().UnixNano()
+		// 半开的连接上写入可能阻塞，不能耽误超时检查
+		go sc.sendPing()
+		timer.Reset(timeout)
+		select {
+		case <-timer.C:
+		case <-sc.ctx.Done():
+			return
+		}
+		if sc.lastRead.Load() < sent {
+			log.Println("rpc server: keepalive timeout, closing connection")
+			_ = sc.cc.Close()
+			return
+		}
+		timer.Reset(interval)
+	}
+}
+
+// reapIdle 关闭超过 idle 没有处理中请求的连接，关闭前发送 go away，客户端不会再在该连接上发起调用
+func (s *Server) reapIdle(sc *serverConn, idle time.Duration) {
+	ticker := time.NewTicker(max(idle/4, shutdownPollInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-sc.ctx.Done():
+			return
+		}
+		if sc.goAwayAt.Load() == 0 && sc.active.Load() == 0 && time.Since(time.Unix(0, sc.lastActive.Load())) >= idle {
+			log.Println("rpc server: closing idle connection")
+			s.sendGoAway(sc)
+		}
+		// 等待 go away 之前发出的请求处理完再关闭
+		if sc.drained() {
+			_ = sc.cc.Close()
+			return
+		}
+	}
+}
+
+func (sc *serverConn) sendPing() {
+	if err := sc.write(&codec.Header{Type: codec.MessagePing}, invalidRequest); err != nil {
+		log.Println("rpc server: send ping error: ", err)
+	}
+}
+
+func (sc *serverConn) sendPong(seq uint64) {
+	if err := sc.write(&codec.Header{Seq: seq, Type: codec.MessagePong}, invalidRequest); err != nil {
+		log.Println("rpc server: send pong error: ", err)
+	}
+}
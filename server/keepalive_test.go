@@ -0,0 +1,127 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/qiancijun/minirpc/codec"
+	"github.com/qiancijun/minirpc/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_Keepalive(t *testing.T) {
+	t.Parallel()
+	_, addr := startTestServer(t, ServerOption{KeepaliveInterval: 20 * time.Millisecond, KeepaliveTimeout: 50 * time.Millisecond})
+
+	t.Run("unanswered", func(t *testing.T) {
+		cc, conn := dialCodec(t, addr)
+		defer func() { _ = cc.Close() }()
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+
+		var h codec.Header
+		require.NoError(t, cc.ReadHeader(&h))
+		require.NoError(t, cc.ReadBody(nil))
+		assert.Equal(t, codec.MessagePing, h.Type)
+		// 不回应 ping，服务端在超时后关闭连接
+		start := time.Now()
+		assert.Error(t, cc.ReadHeader(&h))
+		assert.Less(t, time.Since(start), 500*time.Millisecond)
+	})
+
+	t.Run("answered", func(t *testing.T) {
+		cc, conn := dialCodec(t, addr)
+		defer func() { _ = cc.Close() }()
+
+		deadline := time.Now().Add(200 * time.Millisecond)
+		pings := 0
+		for time.Now().Before(deadline) {
+			_ = conn.SetReadDeadline(deadline)
+			var h codec.Header
+			if err := cc.ReadHeader(&h); err != nil {
+				break
+			}
+			require.NoError(t, cc.ReadBody(nil))
+			require.Equal(t, codec.MessagePing, h.Type)
+			pings++
+			require.NoError(t, cc.Write(&codec.Header{Seq: h.Seq, Type: codec.MessagePong}, struct{}{}))
+		}
+		assert.Greater(t, pings, 1)
+
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		require.NoError(t, cc.Write(&codec.Header{ServiceMethod: "Foo.Sum", Seq: 1}, Args{Num1: 1, Num2: 2}))
+		for {
+			var h codec.Header
+			require.NoError(t, cc.ReadHeader(&h))
+			if h.Type == codec.MessagePing {
+				require.NoError(t, cc.ReadBody(nil))
+				continue
+			}
+			var reply int
+			require.NoError(t, cc.ReadBody(&reply))
+			assert.Equal(t, 3, reply)
+			break
+		}
+	})
+
+	t.Run("unframed client", func(t *testing.T) {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		opt := &common.Option{MagicNumber: common.MagicNumber, CodecType: codec.GobType}
+		require.NoError(t, json.NewEncoder(conn).Encode(opt))
+		cc := codec.NewCodec(conn, opt.CodecType, opt.Version, 0)
+		defer func() { _ = cc.Close() }()
+
+		// 旧版客户端不认识 ping，空闲超过心跳超时后连接仍然可用
+		time.Sleep(150 * time.Millisecond)
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		require.NoError(t, cc.Write(&codec.Header{ServiceMethod: "Foo.Sum", Seq: 1}, Args{Num1: 1, Num2: 2}))
+		var h codec.Header
+		require.NoError(t, cc.ReadHeader(&h))
+		assert.Equal(t, codec.MessageResponse, h.Type)
+		assert.Equal(t, uint64(1), h.Seq)
+		var reply int
+		require.NoError(t, cc.ReadBody(&reply))
+		assert.Equal(t, 3, reply)
+	})
+
+	t.Run("client ping", func(t *testing.T) {
+		cc, conn := dialCodec(t, addr)
+		defer func() { _ = cc.Close() }()
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+
+		require.NoError(t, cc.Write(&codec.Header{Seq: 7, Type: codec.MessagePing}, struct{}{}))
+		var h codec.Header
+		require.NoError(t, cc.ReadHeader(&h))
+		require.NoError(t, cc.ReadBody(nil))
+		assert.Equal(t, codec.MessagePong, h.Type)
+		assert.Equal(t, uint64(7), h.Seq)
+	})
+}
+
+func TestServer_IdleTimeout(t *testing.T) {
+	t.Parallel()
+	_, addr := startTestServer(t, ServerOption{IdleTimeout: 50 * time.Millisecond})
+	cc, conn := dialCodec(t, addr)
+	defer func() { _ = cc.Close() }()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	// 处理中的请求超过空闲时间也不会被关闭
+	require.NoError(t, cc.Write(&codec.Header{ServiceMethod: "Foo.Sleep", Seq: 1}, Args{Num1: 150, Num2: 1}))
+	var h codec.Header
+	require.NoError(t, cc.ReadHeader(&h))
+	assert.Equal(t, uint64(1), h.Seq)
+	assert.Empty(t, h.Error)
+	var reply int
+	require.NoError(t, cc.ReadBody(&reply))
+	assert.Equal(t, 151, reply)
+
+	start := time.Now()
+	require.NoError(t, cc.ReadHeader(&h))
+	require.NoError(t, cc.ReadBody(nil))
+	assert.Equal(t, codec.MessageGoAway, h.Type)
+	assert.GreaterOrEqual(t, time.Since(start), 25*time.Millisecond)
+	assert.Error(t, cc.ReadHeader(&h), "idle connection should be closed")
+}
@@ -1,9 +1,11 @@
 package server
 
 import (
+	"context"
 	"reflect"
 
 	"github.com/qiancijun/minirpc/codec"
+	"github.com/qiancijun/minirpc/metadata"
 	"github.com/qiancijun/minirpc/service"
 )
 
@@ -12,4 +14,7 @@ type request struct {
 	argv, replyv reflect.Value
 	mtype        *service.MethodType
 	svc          *service.Service
+	ctx          context.Context // 请求的截止时间与取消信号
+	trailer      metadata.MD     // 处理方法通过 metadata.SetTrailer 设置的 trailer
+	stream       *serverStream   // 流式调用的发送端，一应一答的调用为 nil
 }
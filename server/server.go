@@ -1,28 +1,55 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"fmt"
 	"io"
 	"log"
 	"net"
 	"net/http"
 	"reflect"
+	runtimedebug "runtime/debug"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/qiancijun/minirpc/codec"
 	"github.com/qiancijun/minirpc/common"
 	"github.com/qiancijun/minirpc/errs"
+	"github.com/qiancijun/minirpc/metadata"
+	"github.com/qiancijun/minirpc/metrics"
 	"github.com/qiancijun/minirpc/service"
+	"github.com/qiancijun/minirpc/trace"
 )
 
 type Server struct {
-	serviceMap sync.Map
+	serviceMap   sync.Map
+	interceptors []UnaryServerInterceptor
+	httpOpts     ServerOption // ServeHTTP 处理 CONNECT 连接时使用的选项
+
+	mu         sync.Mutex
+	listeners  map[net.Listener]struct{}
+	conns      map[*serverConn]struct{}
+	inShutdown atomic.Bool
 }
 
 type ServerOption struct {
-	Timeout time.Duration
+	Timeout        time.Duration     // 一应一答调用的处理时限，0 表示不限制；流式调用只受客户端的截止时间限制
+	MaxMessageSize uint32            // 分帧协议下单帧的最大长度，0 表示使用默认值
+	TLSConfig      *tls.Config       // 不为 nil 时 Accept 只接受 TLS 连接，设置 ClientAuth 可以校验客户端证书
+	Authenticator  Authenticator     // 不为 nil 时每个请求都必须通过握手或请求元数据中的凭证认证
+	Authorizer     Authorizer        // 不为 nil 时在调用服务方法前检查调用方的权限
+	Metrics        *metrics.Registry // 记录调用指标的注册表，nil 表示 metrics.DefaultRegistry
+	Tracer         *trace.Tracer     // 不为 nil 时为每个请求创建 span，并延续客户端传来的 traceparent
+
+	// 连接上 KeepaliveInterval 内没有收到任何消息就向客户端发送 ping，
+	// 之后 KeepaliveTimeout 内仍然没有消息则认为连接已经失效并关闭
+	KeepaliveInterval time.Duration // 0 表示不发送心跳
+	KeepaliveTimeout  time.Duration // 0 表示使用 common.DefaultKeepaliveTimeout
+	IdleTimeout       time.Duration // 没有处理中的请求超过这么久的连接会被关闭，0 表示不关闭
 }
 
 var (
@@ -31,10 +58,17 @@ var (
 		Timeout: 10 * time.Second,
 	}
 	invalidRequest = struct{}{}
+	capabilities   = []string{common.CapabilityFrame, common.CapabilityCancel, common.CapabilityStream, common.CapabilityKeepalive}
 )
 
 func NewServer() *Server {
-	return &Server{}
+	return &Server{httpOpts: DefaultServerOption}
+}
+
+// SetHTTPOption 设置通过 HandleHTTP 接入的连接使用的选项，需要在处理请求前设置。
+// 设置了 TLSConfig 时只接受经由 HTTPS 建立的 CONNECT 连接，证书由 http.Server 的 TLS 配置校验
+func (s *Server) SetHTTPOption(opts ServerOption) {
+	s.httpOpts = opts
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -44,6 +78,11 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		_, _ = io.WriteString(w, "405  must CONNECT\n")
 		return
 	}
+	opts := s.httpOpts
+	if opts.TLSConfig != nil && r.TLS == nil {
+		http.Error(w, "rpc server: TLS required", http.StatusForbidden)
+		return
+	}
 
 	conn, _, err := w.(http.Hijacker).Hijack()
 
@@ -52,7 +91,7 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	_, _ = io.WriteString(conn, "HTTP/1.0 "+common.Connected+"\n\n")
-	s.ServeConn(conn, DefaultServerOption)
+	s.ServeConn(conn, opts)
 }
 
 func (s *Server) HandleHTTP() {
@@ -61,11 +100,32 @@ func (s *Server) HandleHTTP() {
 	log.Println("rpc server debug path: ", common.DefaultDebugPath)
 }
 
+// HandleMetrics 在 common.DefaultMetricsPath 上输出 SetHTTPOption 中配置的指标注册表，
+// 未配置时输出 metrics.DefaultRegistry
+func (s *Server) HandleMetrics() {
+	r := s.httpOpts.Metrics
+	if r == nil {
+		r = metrics.DefaultRegistry
+	}
+	http.Handle(common.DefaultMetricsPath, metrics.Handler(r))
+	log.Println("rpc server metrics path: ", common.DefaultMetricsPath)
+}
+
 func (s *Server) Accept(lis net.Listener, opts ServerOption) {
+	if opts.TLSConfig != nil {
+		lis = tls.NewListener(lis, opts.TLSConfig)
+	}
+	if !s.trackListener(lis, true) {
+		_ = lis.Close()
+		return
+	}
+	defer s.trackListener(lis, false)
 	for {
 		conn, err := lis.Accept()
 		if err != nil {
-			log.Println("rpc server: accept error: ", err)
+			if !s.shuttingDown() {
+				log.Println("rpc server: accept error: ", err)
+			}
 			return
 		}
 		go s.ServeConn(conn, opts)
@@ -77,23 +137,85 @@ func (s *Server) ServeConn(conn io.ReadWriteCloser, opts ServerOption) {
 		_ = conn.Close()
 	}()
 
+	peer := &Peer{}
+	if nc, ok := conn.(interface{ RemoteAddr() net.Addr }); ok {
+		peer.Addr = nc.RemoteAddr()
+	}
+	if tc, ok := conn.(*tls.Conn); ok {
+		// 先完成 TLS 握手，使处理方法能拿到客户端证书
+		if err := tc.Handshake(); err != nil {
+			log.Println("rpc server: tls handshake error: ", err)
+			return
+		}
+		state := tc.ConnectionState()
+		peer.TLS = &state
+	}
+	conn = metrics.NewRPC(opts.Metrics, "server").Conn(conn)
+
 	var opt common.Option
-	if err := json.NewDecoder(conn).Decode(&opt); err != nil {
+	dec := json.NewDecoder(conn)
+	if err := dec.Decode(&opt); err != nil {
 		log.Println("rpc server: options error: ", err)
 		return
 	}
 
-	if opt.MagicNumber != common.MagicNumber {
-		log.Printf("rpc server: invalid magic number %x", opt.MagicNumber)
+	ctx := context.WithValue(context.Background(), peerKey{}, peer)
+	resp := s.handshake(&opt)
+	if resp.Reason == common.HandshakeAccepted {
+		var err error
+		if ctx, err = authenticateConn(ctx, opts.Authenticator, opt.Token); err != nil {
+			resp.Reason = common.RejectUnauthenticated
+			resp.Message = err.Error()
+		}
+	}
+	// Version 为 0 的旧版客户端不等待握手应答，直接开始发送请求
+	if opt.Version != 0 {
+		if err := json.NewEncoder(conn).Encode(resp); err != nil {
+			log.Println("rpc server: handshake response error: ", err)
+			return
+		}
+	}
+	if resp.Reason != common.HandshakeAccepted {
+		log.Println("rpc server: handshake rejected: ", resp.Message)
 		return
 	}
 
-	f := codec.NewCodecFuncMap[opt.CodecType]
-	if f == nil {
-		log.Printf("rpc server: invalid codec type %s", opt.CodecType)
-		return
+	// json.Decoder 可能预读了 Option 之后的数据，需要交还给编解码器
+	cc := codec.NewCodec(common.NewHandshakeConn(conn, dec.Buffered()), opt.CodecType, opt.Version, opts.MaxMessageSize)
+	// 客户端声明的 HandleTimeout 比服务端更严格时以客户端为准
+	if opt.HandleTimeout > 0 && (opts.Timeout == 0 || opt.HandleTimeout < opts.Timeout) {
+		opts.Timeout = opt.HandleTimeout
 	}
-	s.serveCodec(f(conn), opts.Timeout)
+	// Version 为 0 的旧版客户端不会回应 ping，不对其发送心跳
+	if opt.Version == 0 {
+		opts.KeepaliveInterval = 0
+	}
+	s.serveCodec(ctx, cc, opts)
+}
+
+func (s *Server) handshake(opt *common.Option) *common.HandshakeResponse {
+	resp := &common.HandshakeResponse{
+		CodecType:    opt.CodecType,
+		Version:      opt.Version,
+		Capabilities: capabilities,
+	}
+	switch {
+	case opt.MagicNumber != common.MagicNumber:
+		resp.Reason = common.RejectInvalidMagicNumber
+		resp.Message = fmt.Sprintf("invalid magic number %x", opt.MagicNumber)
+	case opt.Version != 0 && opt.Version != codec.FrameVersion:
+		resp.Reason = common.RejectUnsupportedVersion
+		resp.Message = fmt.Sprintf("unsupported protocol version %d", opt.Version)
+	case codec.NewCodecFuncMap[opt.CodecType] == nil || codec.SerializerMap[opt.CodecType] == nil:
+		resp.Reason = common.RejectUnsupportedCodec
+		resp.Message = fmt.Sprintf("invalid codec type %s", opt.CodecType)
+	}
+	return resp
+}
+
+// Use 追加服务端拦截器，先追加的位于外层；需要在开始处理连接前设置
+func (s *Server) Use(interceptors ...UnaryServerInterceptor) {
+	s.interceptors = append(s.interceptors, interceptors...)
 }
 
 func (s *Server) Register(rcvr interface{}) error {
@@ -104,111 +226,289 @@ func (s *Server) Register(rcvr interface{}) error {
 	return nil
 }
 
-func (s *Server) serveCodec(cc codec.Codec, timeout time.Duration) {
-	sending := new(sync.Mutex)
-	wg := new(sync.WaitGroup)
+func (s *Server) serveCodec(ctx context.Context, cc codec.Codec, opts ServerOption) {
+	sc := newServerConn(ctx, cc, opts)
+	if !s.trackConn(sc, true) {
+		_ = cc.Close()
+		return
+	}
+	defer s.trackConn(sc, false)
+	if opts.KeepaliveInterval > 0 {
+		timeout := opts.KeepaliveTimeout
+		if timeout == 0 {
+			timeout = common.DefaultKeepaliveTimeout
+		}
+		go sc.keepalive(opts.KeepaliveInterval, timeout)
+	}
+	if opts.IdleTimeout > 0 {
+		go s.reapIdle(sc, opts.IdleTimeout)
+	}
 	for {
-		req, err := s.readRequest(cc)
+		req, err := s.readRequest(sc)
 		if err != nil {
 			if req == nil {
 				break
 			}
-			req.h.Error = err.Error()
-			s.sendResponse(cc, req.h, invalidRequest, sending)
+			if req.h.OneWay {
+				// 单向调用没有响应，错误只能记录在服务端
+				log.Printf("rpc server: drop one-way call %s: %v\n", req.h.ServiceMethod, err)
+				continue
+			}
+			setError(req.h, err)
+			req.h.Metadata = nil
+			s.sendResponse(sc, req.h, invalidRequest)
+			continue
+		}
+		switch req.h.Type {
+		case codec.MessageCancel:
+			sc.cancelCall(req.h.Seq)
+			continue
+		case codec.MessageWindowUpdate:
+			sc.updateWindow(req.h.StreamID, req.h.Window)
+			continue
+		case codec.MessageStreamEnd:
+			sc.closeStreamRecv(req.h.StreamID)
+			continue
+		case codec.MessageStream:
+			// 消息已经在 readRequest 中交给对应的流
+			continue
+		case codec.MessagePing:
+			sc.sendPong(req.h.Seq)
+			continue
+		case codec.MessagePong:
+			continue
+		}
+		// 先登记为处理中，避免请求在检查与派发之间被当作空闲连接关闭
+		sc.active.Add(1)
+		if s.shuttingDown() {
+			// 已经发送过 go away，拒绝之后到达的请求
+			sc.cancelCall(req.h.Seq)
+			if !req.h.OneWay {
+				setError(req.h, errs.ErrServerShutdown)
+				req.h.Metadata = nil
+				s.sendResponse(sc, req.h, invalidRequest)
+			}
+			sc.active.Add(-1)
 			continue
 		}
-		wg.Add(1)
-		go s.handleRequest(cc, req, sending, wg, timeout)
+		sc.lastActive.Store(time.Now().UnixNano())
+		sc.wg.Add(1)
+		go s.handleRequest(sc, req)
 	}
-	wg.Add(1)
+	// 连接已断开，取消所有处理中的请求
+	sc.cancel()
+	sc.wg.Wait()
 	_ = cc.Close()
 }
 
-func (s *Server) readRequest(cc codec.Codec) (*request, error) {
+func (s *Server) readRequest(sc *serverConn) (*request, error) {
+	cc := sc.cc
 	h, err := s.readRequestHeader(cc)
 	if err != nil {
 		return nil, err
 	}
 
+	sc.lastRead.Store(time.Now().UnixNano())
+
 	req := &request{
 		h: h,
 	}
+	switch h.Type {
+	case codec.MessageCancel, codec.MessageWindowUpdate, codec.MessageStreamEnd, codec.MessagePing, codec.MessagePong:
+		if err := cc.ReadBody(nil); err != nil {
+			return nil, err
+		}
+		return req, nil
+	case codec.MessageStream:
+		if err := sc.readStreamMessage(h); err != nil {
+			return nil, err
+		}
+		return req, nil
+	}
 
 	req.svc, req.mtype, err = s.findService(h.ServiceMethod)
 	if err != nil {
 		return req, err
 	}
-	req.argv = req.mtype.NewArgv()
-	req.replyv = req.mtype.NewReplyv()
-
-	argvi := req.argv.Interface()
-	if req.argv.Type().Kind() != reflect.Ptr {
-		argvi = req.argv.Addr().Interface()
+	if !req.mtype.ServerStreams() {
+		req.replyv = req.mtype.NewReplyv()
 	}
-
-	if err := cc.ReadBody(argvi); err != nil {
-		log.Println("rpc server: read argv err: ", err)
-		return req, err
+	if req.mtype.ClientStreams() {
+		// 客户端流的参数随后通过流消息到达，发起请求的 body 为空
+		if err := cc.ReadBody(nil); err != nil {
+			return nil, err
+		}
+	} else {
+		req.argv = req.mtype.NewArgv()
+		argvi := req.argv.Interface()
+		if req.argv.Type().Kind() != reflect.Ptr {
+			argvi = req.argv.Addr().Interface()
+		}
+		if err := cc.ReadBody(argvi); err != nil {
+			log.Println("rpc server: read argv err: ", err)
+			return req, errs.NewStatus(errs.CodeInvalidArgument, err.Error())
+		}
+	}
+	// 客户端以非零的 StreamID 发起流式调用，StreamID 必须等于发起请求的序列号，
+	// 之后的流消息、窗口更新与取消消息都按这个编号找到流
+	if req.mtype.IsStream() != (h.StreamID != 0) || (h.StreamID != 0 && h.StreamID != h.Seq) {
+		return req, errs.ErrStreamMismatch
+	}
+	// 在读循环中登记请求，保证随后到达的取消消息一定能找到它
+	req.ctx = sc.newRequestContext(h, req.mtype.IsStream())
+	if h.Metadata != nil {
+		req.ctx = metadata.NewIncomingContext(req.ctx, h.Metadata)
+	}
+	req.ctx = metadata.NewServerTrailerContext(req.ctx, &req.trailer)
+	if req.mtype.IsStream() {
+		req.stream = sc.registerStream(req)
+		if req.mtype.ClientStreams() {
+			req.argv = req.stream.in
+		}
 	}
 	return req, nil
 }
 
-func (s *Server) sendResponse(cc codec.Codec, h *codec.Header, body interface{}, sending *sync.Mutex) {
-	sending.Lock()
-	defer sending.Unlock()
-	if err := cc.Write(h, body); err != nil {
+func (s *Server) sendResponse(sc *serverConn, h *codec.Header, body interface{}) {
+	h.Type = codec.MessageResponse
+	err := sc.write(h, body)
+	if err == errs.ErrFrameTooLarge {
+		// 响应超过大小限制时仍然要通知客户端，否则调用方会一直等待
+		setError(h, err)
+		err = sc.write(h, invalidRequest)
+	}
+	if err != nil {
 		log.Println("rpc server: write response error: ", err)
 	}
 }
 
-func (s *Server) handleRequest(cc codec.Codec, req *request, sending *sync.Mutex, wg *sync.WaitGroup, timeout time.Duration) {
-	defer wg.Done()
+// sendResult 发送请求的最终结果，流式调用以不带 body 的结束消息代替响应，
+// 客户端流方法的 reply 作为结束前的最后一条流消息发送
+func (s *Server) sendResult(sc *serverConn, req *request, err error) {
+	if req.h.OneWay {
+		if err != nil {
+			log.Printf("rpc server: one-way call %s failed: %v\n", req.h.ServiceMethod, err)
+		}
+		return
+	}
+	if err != nil {
+		setError(req.h, err)
+	}
+	if req.stream == nil {
+		var body interface{} = invalidRequest
+		if err == nil {
+			body = req.replyv.Interface()
+		}
+		s.sendResponse(sc, req.h, body)
+		return
+	}
+	if err == nil && req.replyv.IsValid() {
+		if err := req.stream.write(req.replyv.Interface()); err != nil {
+			setError(req.h, err)
+		}
+	}
+	req.h.Type = codec.MessageStreamEnd
+	if err := sc.write(req.h, invalidRequest); err != nil {
+		log.Println("rpc server: write stream end error: ", err)
+	}
+}
 
-	finish := make(chan struct{})
-	defer close(finish)
-	called := make(chan struct{})
-	sent := make(chan struct{})
+func (s *Server) handleRequest(sc *serverConn, req *request) {
+	defer sc.wg.Done()
+	defer func() {
+		sc.lastActive.Store(time.Now().UnixNano())
+		sc.active.Add(-1)
+	}()
+	defer sc.cancelCall(req.h.Seq)
+	finish := sc.metrics.Begin(req.h.ServiceMethod)
+	if req.h.OneWay {
+		sc.metrics.OneWay(req.h.ServiceMethod)
+	}
 
+	// 方法返回前请求可能已经超时或被取消，缓冲避免 goroutine 泄漏
+	called := make(chan error, 1)
 	go func() {
-		err := req.svc.Call(req.mtype, req.argv, req.replyv)
-		select {
-		case <-finish:
-			close(called)
-			close(sent)
-			return
-		case called <- struct{}{}:
-			if err != nil {
-				req.h.Error = err.Error()
-				s.sendResponse(cc, req.h, invalidRequest, sending)
-				sent <- struct{}{}
-				return
+		// 服务方法的 panic 已由 Service.Call 处理，这里兜底拦截器中的 panic
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("rpc server: %s interceptor panic: %v\n%s", req.h.ServiceMethod, r, runtimedebug.Stack())
+				called <- errs.NewStatus(errs.CodeInternal, fmt.Sprintf("rpc server: %s panic: %v", req.h.ServiceMethod, r))
 			}
-			s.sendResponse(cc, req.h, req.replyv.Interface(), sending)
-			sent <- struct{}{}
-		}
+		}()
+		called <- s.invoke(sc, req)
 	}()
 
-	if timeout == 0 {
-		<-called
-		<-sent
-		return
+	// 响应头中的 Metadata 用来携带 trailer，不能回传请求元数据
+	req.h.Metadata = nil
+	select {
+	case <-req.ctx.Done():
+		// 被客户端取消或连接已断开时，对端不再等待响应
+		if req.ctx.Err() == context.DeadlineExceeded {
+			finish(errs.ErrServiceHandleTimeout)
+			s.sendResult(sc, req, errs.ErrServiceHandleTimeout)
+			return
+		}
+		finish(req.ctx.Err())
+	case err := <-called:
+		finish(err)
+		req.h.Metadata = req.trailer
+		s.sendResult(sc, req, err)
 	}
+}
 
-	select {
-	case <-time.After(timeout):
-		req.h.Error = errs.ErrServiceHandleTimeout.Error()
-		s.sendResponse(cc, req.h, invalidRequest, sending)
-		finish <- struct{}{}
-	case <-called:
-		<-sent
+// setError 将 err 转换为错误码与错误信息写入响应头
+func setError(h *codec.Header, err error) {
+	st := errs.FromError(err)
+	h.Code = uint32(st.Code)
+	h.Error = st.Message
+	h.Details = st.Details
+}
+
+// invoke 创建服务端 span，完成认证与鉴权后经过拦截器链调用服务方法
+func (s *Server) invoke(sc *serverConn, req *request) (err error) {
+	ctx, span := sc.tracer.Start(trace.Extract(req.ctx), req.h.ServiceMethod, trace.KindServer)
+	if span != nil {
+		if p, ok := PeerFromContext(ctx); ok && p.Addr != nil {
+			span.SetAttribute("rpc.peer", p.Addr.String())
+		}
+		defer func() { span.Finish(err) }()
+	}
+	ctx, err = sc.authorize(ctx, req.h.ServiceMethod)
+	if err != nil {
+		return err
+	}
+	if req.stream != nil {
+		// 流式调用不经过一应一答的拦截器
+		req.stream.callCtx = ctx
+		replyv := req.replyv
+		if req.mtype.ServerStreams() {
+			replyv = reflect.ValueOf(req.stream)
+		}
+		return req.svc.Call(ctx, req.mtype, req.argv, replyv)
+	}
+	// 以拦截器链传入的 args 与 reply 调用服务方法，拦截器可以替换它们，但类型必须与方法一致
+	handler := func(ctx context.Context, args, reply interface{}) error {
+		argv, replyv := reflect.ValueOf(args), reflect.ValueOf(reply)
+		if !argv.IsValid() || argv.Type() != req.argv.Type() || !replyv.IsValid() || replyv.Type() != req.replyv.Type() {
+			return errs.NewStatus(errs.CodeInternal, "rpc server: interceptor passed arguments of the wrong type to "+req.h.ServiceMethod)
+		}
+		// 响应中发送的是服务方法实际写入的 reply
+		req.replyv = replyv
+		return req.svc.Call(ctx, req.mtype, argv, replyv)
 	}
+	args, reply := req.argv.Interface(), req.replyv.Interface()
+	if len(s.interceptors) == 0 {
+		return handler(ctx, args, reply)
+	}
+	info := &UnaryServerInfo{ServiceMethod: req.h.ServiceMethod}
+	return chainHandler(s.interceptors, info, handler)(ctx, args, reply)
 }
 
 func (s *Server) readRequestHeader(cc codec.Codec) (*codec.Header, error) {
 	var h codec.Header
 	if err := cc.ReadHeader(&h); err != nil {
-		if err != io.EOF && err != io.ErrUnexpectedEOF {
-			log.Panicln("rpc server: read header error: ", err)
+		if err != io.EOF && err != io.ErrUnexpectedEOF && !s.shuttingDown() {
+			log.Println("rpc server: read header error: ", err)
 		}
 		return nil, err
 	}
@@ -244,3 +544,7 @@ func Register(rcvr interface{}) error {
 func HandleHTTP() {
 	DefaultServer.HandleHTTP()
 }
+
+func HandleMetrics() {
+	DefaultServer.HandleMetrics()
+}
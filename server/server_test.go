@@ -0,0 +1,412 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qiancijun/minirpc/codec"
+	"github.com/qiancijun/minirpc/common"
+	"github.com/qiancijun/minirpc/errs"
+	"github.com/qiancijun/minirpc/metrics"
+	"github.com/qiancijun/minirpc/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type Foo int
+
+type Args struct{ Num1, Num2 int }
+
+func (f Foo) Sum(args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+// Sleep 休眠 Num1 毫秒
+func (f Foo) Sleep(args Args, reply *int) error {
+	time.Sleep(time.Duration(args.Num1) * time.Millisecond)
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+// Peer 返回调用方地址，要求请求 context 带有截止时间
+func (f Foo) Peer(ctx context.Context, args Args, reply *string) error {
+	p, ok := PeerFromContext(ctx)
+	if !ok {
+		return errors.New("missing peer")
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		return errors.New("missing deadline")
+	}
+	*reply = p.Addr.String()
+	return nil
+}
+
+// Ticks 依次发送 0 到 n-1
+func (f Foo) Ticks(n int, stream service.ServerStream) error {
+	for i := 0; i < n; i++ {
+		if err := stream.Send(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f Foo) Panic(args Args, reply *int) error {
+	panic("boom")
+}
+
+func startTestServer(t *testing.T, opts ServerOption) (*Server, string) {
+	s := NewServer()
+	require.NoError(t, s.Register(new(Foo)))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go s.Accept(l, opts)
+	return s, l.Addr().String()
+}
+
+// dialCodec 完成握手后直接返回编解码器，便于构造任意消息
+func dialCodec(t *testing.T, addr string) (codec.Codec, net.Conn) {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	opt := common.DefaultOption
+	require.NoError(t, json.NewEncoder(conn).Encode(opt))
+	dec := json.NewDecoder(conn)
+	var resp common.HandshakeResponse
+	require.NoError(t, dec.Decode(&resp))
+	require.NoError(t, resp.Err())
+	return codec.NewCodec(common.NewHandshakeConn(conn, dec.Buffered()), opt.CodecType, opt.Version, 0), conn
+}
+
+func TestServer_Deadline(t *testing.T) {
+	t.Parallel()
+	_, addr := startTestServer(t, ServerOption{})
+	cc, _ := dialCodec(t, addr)
+	defer func() { _ = cc.Close() }()
+
+	start := time.Now()
+	h := &codec.Header{ServiceMethod: "Foo.Sleep", Seq: 1, Timeout: int64(100 * time.Millisecond)}
+	require.NoError(t, cc.Write(h, Args{Num1: 1000}))
+
+	var resp codec.Header
+	require.NoError(t, cc.ReadHeader(&resp))
+	require.NoError(t, cc.ReadBody(nil))
+	assert.Equal(t, errs.ErrServiceHandleTimeout.Error(), resp.Error)
+	assert.Less(t, time.Since(start), 500*time.Millisecond, "server should honour the client deadline")
+}
+
+func TestServer_Cancel(t *testing.T) {
+	t.Parallel()
+	_, addr := startTestServer(t, ServerOption{})
+	cc, conn := dialCodec(t, addr)
+	defer func() { _ = cc.Close() }()
+
+	require.NoError(t, cc.Write(&codec.Header{ServiceMethod: "Foo.Sleep", Seq: 1}, Args{Num1: 300}))
+	require.NoError(t, cc.Write(&codec.Header{Seq: 1, Type: codec.MessageCancel}, struct{}{}))
+	require.NoError(t, cc.Write(&codec.Header{ServiceMethod: "Foo.Sum", Seq: 2}, Args{Num1: 1, Num2: 2}))
+
+	var h codec.Header
+	require.NoError(t, cc.ReadHeader(&h))
+	assert.Equal(t, uint64(2), h.Seq)
+	var reply int
+	require.NoError(t, cc.ReadBody(&reply))
+	assert.Equal(t, 3, reply)
+
+	// 被取消的请求不会再收到响应
+	_ = conn.SetReadDeadline(time.Now().Add(600 * time.Millisecond))
+	err := cc.ReadHeader(&h)
+	var netErr net.Error
+	require.ErrorAs(t, err, &netErr)
+	assert.True(t, netErr.Timeout())
+}
+
+func TestServer_RequestContext(t *testing.T) {
+	t.Parallel()
+	_, addr := startTestServer(t, ServerOption{})
+	cc, conn := dialCodec(t, addr)
+	defer func() { _ = cc.Close() }()
+
+	h := &codec.Header{ServiceMethod: "Foo.Peer", Seq: 1, Timeout: int64(time.Second)}
+	require.NoError(t, cc.Write(h, Args{}))
+
+	var resp codec.Header
+	require.NoError(t, cc.ReadHeader(&resp))
+	require.Empty(t, resp.Error)
+	var reply string
+	require.NoError(t, cc.ReadBody(&reply))
+	assert.Equal(t, conn.LocalAddr().String(), reply)
+}
+
+func TestServer_Interceptor(t *testing.T) {
+	t.Parallel()
+	s := NewServer()
+	require.NoError(t, s.Register(new(Foo)))
+	var order []string
+	record := func(name string) UnaryServerInterceptor {
+		return func(ctx context.Context, info *UnaryServerInfo, args, reply interface{}, handler UnaryHandler) error {
+			order = append(order, name+" before "+info.ServiceMethod)
+			err := handler(ctx, args, reply)
+			order = append(order, name+" after")
+			return err
+		}
+	}
+	deny := func(ctx context.Context, info *UnaryServerInfo, args, reply interface{}, handler UnaryHandler) error {
+		if info.ServiceMethod == "Foo.Sleep" {
+			return errors.New("denied")
+		}
+		return handler(ctx, args, reply)
+	}
+	s.Use(record("a"), record("b"), deny)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go s.Accept(l, ServerOption{})
+
+	cc, _ := dialCodec(t, l.Addr().String())
+	defer func() { _ = cc.Close() }()
+
+	require.NoError(t, cc.Write(&codec.Header{ServiceMethod: "Foo.Sum", Seq: 1}, Args{Num1: 1, Num2: 2}))
+	var h codec.Header
+	require.NoError(t, cc.ReadHeader(&h))
+	var reply int
+	require.NoError(t, cc.ReadBody(&reply))
+	assert.Equal(t, 3, reply)
+	assert.Equal(t, []string{"a before Foo.Sum", "b before Foo.Sum", "b after", "a after"}, order)
+
+	require.NoError(t, cc.Write(&codec.Header{ServiceMethod: "Foo.Sleep", Seq: 2}, Args{Num1: 1}))
+	require.NoError(t, cc.ReadHeader(&h))
+	require.NoError(t, cc.ReadBody(nil))
+	assert.Equal(t, "denied", h.Error)
+}
+
+func TestServer_InterceptorReplacesArgs(t *testing.T) {
+	t.Parallel()
+	s := NewServer()
+	require.NoError(t, s.Register(new(Foo)))
+	// 把参数规范为非负数，并换用新的 reply
+	s.Use(func(ctx context.Context, info *UnaryServerInfo, args, reply interface{}, handler UnaryHandler) error {
+		a := args.(Args)
+		a.Num1, a.Num2 = max(a.Num1, 0), max(a.Num2, 0)
+		if info.ServiceMethod == "Foo.Panic" {
+			return handler(ctx, &a, reply)
+		}
+		r := new(int)
+		err := handler(ctx, a, r)
+		*r *= 10
+		return err
+	})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go s.Accept(l, ServerOption{})
+	cc, _ := dialCodec(t, l.Addr().String())
+	defer func() { _ = cc.Close() }()
+
+	require.NoError(t, cc.Write(&codec.Header{ServiceMethod: "Foo.Sum", Seq: 1}, Args{Num1: -5, Num2: 2}))
+	var h codec.Header
+	require.NoError(t, cc.ReadHeader(&h))
+	var reply int
+	require.NoError(t, cc.ReadBody(&reply))
+	assert.Empty(t, h.Error)
+	assert.Equal(t, 20, reply)
+
+	// 类型不符的参数不会传给服务方法
+	require.NoError(t, cc.Write(&codec.Header{ServiceMethod: "Foo.Panic", Seq: 2}, Args{}))
+	h = codec.Header{}
+	require.NoError(t, cc.ReadHeader(&h))
+	require.NoError(t, cc.ReadBody(nil))
+	assert.Equal(t, uint32(errs.CodeInternal), h.Code)
+	assert.Contains(t, h.Error, "wrong type")
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	t.Parallel()
+	s, addr := startTestServer(t, ServerOption{})
+	cc, _ := dialCodec(t, addr)
+	defer func() { _ = cc.Close() }()
+
+	require.NoError(t, cc.Write(&codec.Header{ServiceMethod: "Foo.Sleep", Seq: 1}, Args{Num1: 200, Num2: 1}))
+	time.Sleep(50 * time.Millisecond)
+	done := make(chan error, 1)
+	go func() { done <- s.Shutdown(context.Background()) }()
+
+	var h codec.Header
+	require.NoError(t, cc.ReadHeader(&h))
+	require.NoError(t, cc.ReadBody(nil))
+	assert.Equal(t, codec.MessageGoAway, h.Type)
+
+	// 处理中的请求正常完成
+	require.NoError(t, cc.ReadHeader(&h))
+	assert.Equal(t, uint64(1), h.Seq)
+	assert.Empty(t, h.Error)
+	var reply int
+	require.NoError(t, cc.ReadBody(&reply))
+	assert.Equal(t, 201, reply)
+
+	require.NoError(t, <-done)
+	assert.Error(t, cc.ReadHeader(&h), "connection should be closed after shutdown")
+	_, err := net.DialTimeout("tcp", addr, time.Second)
+	assert.Error(t, err, "listener should be closed after shutdown")
+}
+
+func TestServer_StreamID(t *testing.T) {
+	t.Parallel()
+	_, addr := startTestServer(t, ServerOption{})
+	cc, _ := dialCodec(t, addr)
+	defer func() { _ = cc.Close() }()
+
+	// StreamID 与序列号不一致的流被拒绝
+	require.NoError(t, cc.Write(&codec.Header{ServiceMethod: "Foo.Ticks", Seq: 1, StreamID: 2}, 1))
+	var h codec.Header
+	require.NoError(t, cc.ReadHeader(&h))
+	require.NoError(t, cc.ReadBody(nil))
+	assert.Equal(t, uint64(1), h.Seq)
+	assert.Equal(t, errs.ErrStreamMismatch.Error(), h.Error)
+
+	require.NoError(t, cc.Write(&codec.Header{ServiceMethod: "Foo.Ticks", Seq: 3, StreamID: 3}, 1))
+	h = codec.Header{}
+	require.NoError(t, cc.ReadHeader(&h))
+	var n int
+	require.NoError(t, cc.ReadBody(&n))
+	assert.Equal(t, codec.MessageStream, h.Type)
+	assert.Equal(t, uint64(3), h.StreamID)
+	assert.Empty(t, h.Error)
+}
+
+func TestServer_ShutdownDrain(t *testing.T) {
+	t.Parallel()
+	s, addr := startTestServer(t, ServerOption{})
+	cc, _ := dialCodec(t, addr)
+	defer func() { _ = cc.Close() }()
+
+	done := make(chan error, 1)
+	go func() { done <- s.Shutdown(context.Background()) }()
+	var h codec.Header
+	require.NoError(t, cc.ReadHeader(&h))
+	require.NoError(t, cc.ReadBody(nil))
+	assert.Equal(t, codec.MessageGoAway, h.Type)
+
+	// 客户端收到 go away 之前发出的请求被明确拒绝，而不是遇到连接关闭
+	require.NoError(t, cc.Write(&codec.Header{ServiceMethod: "Foo.Sum", Seq: 1}, Args{Num1: 1, Num2: 2}))
+	require.NoError(t, cc.ReadHeader(&h))
+	require.NoError(t, cc.ReadBody(nil))
+	assert.Equal(t, uint64(1), h.Seq)
+	assert.Equal(t, errs.ErrServerShutdown.Error(), h.Error)
+	assert.Equal(t, uint32(errs.CodeUnavailable), h.Code)
+
+	require.NoError(t, <-done)
+	assert.Error(t, cc.ReadHeader(&h), "connection should be closed after the drain period")
+}
+
+func TestServer_ShutdownTimeout(t *testing.T) {
+	t.Parallel()
+	s, addr := startTestServer(t, ServerOption{})
+	cc, _ := dialCodec(t, addr)
+	defer func() { _ = cc.Close() }()
+
+	require.NoError(t, cc.Write(&codec.Header{ServiceMethod: "Foo.Sleep", Seq: 1}, Args{Num1: 2000}))
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, s.Shutdown(ctx))
+
+	var h codec.Header
+	require.NoError(t, cc.ReadHeader(&h))
+	require.NoError(t, cc.ReadBody(nil))
+	assert.Equal(t, codec.MessageGoAway, h.Type)
+	assert.Error(t, cc.ReadHeader(&h), "connection should be closed when ctx expires")
+}
+
+func TestServer_Close(t *testing.T) {
+	t.Parallel()
+	s, addr := startTestServer(t, ServerOption{})
+	cc, _ := dialCodec(t, addr)
+	defer func() { _ = cc.Close() }()
+
+	require.NoError(t, cc.Write(&codec.Header{ServiceMethod: "Foo.Sleep", Seq: 1}, Args{Num1: 2000}))
+	time.Sleep(50 * time.Millisecond)
+	s.Close()
+
+	var h codec.Header
+	assert.Error(t, cc.ReadHeader(&h), "connection should be closed immediately")
+}
+
+func TestServer_Panic(t *testing.T) {
+	t.Parallel()
+	_, addr := startTestServer(t, ServerOption{})
+	cc, _ := dialCodec(t, addr)
+	defer func() { _ = cc.Close() }()
+
+	require.NoError(t, cc.Write(&codec.Header{ServiceMethod: "Foo.Panic", Seq: 1}, Args{}))
+	var h codec.Header
+	require.NoError(t, cc.ReadHeader(&h))
+	require.NoError(t, cc.ReadBody(nil))
+	assert.Equal(t, uint32(errs.CodeInternal), h.Code)
+	assert.Contains(t, h.Error, "boom")
+
+	// 同一个连接上的后续请求不受影响
+	require.NoError(t, cc.Write(&codec.Header{ServiceMethod: "Foo.Sum", Seq: 2}, Args{Num1: 1, Num2: 2}))
+	h = codec.Header{}
+	require.NoError(t, cc.ReadHeader(&h))
+	assert.Empty(t, h.Error)
+	var reply int
+	require.NoError(t, cc.ReadBody(&reply))
+	assert.Equal(t, 3, reply)
+}
+
+func TestServer_MalformedHeader(t *testing.T) {
+	t.Parallel()
+	_, addr := startTestServer(t, ServerOption{})
+	bad, badConn := dialCodec(t, addr)
+	defer func() { _ = bad.Close() }()
+	good, _ := dialCodec(t, addr)
+	defer func() { _ = good.Close() }()
+
+	// 非法的帧只会关闭发送它的连接
+	_, err := badConn.Write([]byte("definitely not a frame"))
+	require.NoError(t, err)
+	var h codec.Header
+	assert.Error(t, bad.ReadHeader(&h))
+
+	require.NoError(t, good.Write(&codec.Header{ServiceMethod: "Foo.Sum", Seq: 1}, Args{Num1: 1, Num2: 2}))
+	require.NoError(t, good.ReadHeader(&h))
+	var reply int
+	require.NoError(t, good.ReadBody(&reply))
+	assert.Equal(t, 3, reply)
+}
+
+func TestServer_HandleMetrics(t *testing.T) {
+	reg := metrics.NewRegistry()
+	s, addr := startTestServer(t, ServerOption{Metrics: reg})
+	s.SetHTTPOption(ServerOption{Metrics: reg})
+	// HandleMetrics 注册在 http.DefaultServeMux 上，换成新的 mux 以免重复注册
+	defer func(mux *http.ServeMux) { http.DefaultServeMux = mux }(http.DefaultServeMux)
+	http.DefaultServeMux = http.NewServeMux()
+	s.HandleMetrics()
+	hs := httptest.NewServer(http.DefaultServeMux)
+	defer hs.Close()
+
+	cc, _ := dialCodec(t, addr)
+	defer func() { _ = cc.Close() }()
+	require.NoError(t, cc.Write(&codec.Header{ServiceMethod: "Foo.Sum", Seq: 1}, Args{Num1: 1, Num2: 2}))
+	var h codec.Header
+	require.NoError(t, cc.ReadHeader(&h))
+	require.NoError(t, cc.ReadBody(nil))
+
+	// 调用在发送响应之后才计入指标
+	assert.Eventually(t, func() bool {
+		resp, err := http.Get(hs.URL + common.DefaultMetricsPath)
+		if err != nil {
+			return false
+		}
+		defer func() { _ = resp.Body.Close() }()
+		body, err := io.ReadAll(resp.Body)
+		return err == nil && strings.Contains(string(body), `minirpc_server_calls_total{method="Foo.Sum"} 1`)
+	}, time.Second, 10*time.Millisecond)
+}
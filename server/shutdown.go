@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net"
+	"time"
+
+	"github.com/qiancijun/minirpc/codec"
+)
+
+// shutdownPollInterval 是 Shutdown 检查连接是否空闲的间隔
+const shutdownPollInterval = 10 * time.Millisecond
+
+// goAwayDrainPeriod 是发送 go away 后保留连接的最短时间，客户端在收到 go away 之前发出的请求
+// 仍能到达并被拒绝，而不是因为连接关闭而失败
+const goAwayDrainPeriod = 100 * time.Millisecond
+
+// Shutdown 优雅地关闭服务端：停止接受新连接，通知已连接的客户端不再发送新请求，
+// 等待处理中的请求完成后关闭连接。ctx 到期时立即关闭剩余连接并返回 ctx.Err()
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.inShutdown.Store(true)
+	s.mu.Lock()
+	s.closeListenersLocked()
+	for sc := range s.conns {
+		s.sendGoAway(sc)
+	}
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if s.closeIdleConns() {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			s.Close()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close 立即关闭所有监听器与连接，处理中的请求会被取消
+func (s *Server) Close() {
+	s.inShutdown.Store(true)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closeListenersLocked()
+	for sc := range s.conns {
+		_ = sc.cc.Close()
+		delete(s.conns, sc)
+	}
+}
+
+func (s *Server) shuttingDown() bool {
+	return s.inShutdown.Load()
+}
+
+// trackListener 登记或注销监听器，服务端关闭后登记失败
+func (s *Server) trackListener(lis net.Listener, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]struct{})
+	}
+	if !add {
+		delete(s.listeners, lis)
+		return true
+	}
+	if s.shuttingDown() {
+		return false
+	}
+	s.listeners[lis] = struct{}{}
+	return true
+}
+
+// trackConn 登记或注销连接，服务端关闭后登记失败
+func (s *Server) trackConn(sc *serverConn, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conns == nil {
+		s.conns = make(map[*serverConn]struct{})
+	}
+	if !add {
+		delete(s.conns, sc)
+		return true
+	}
+	if s.shuttingDown() {
+		return false
+	}
+	s.conns[sc] = struct{}{}
+	return true
+}
+
+func (s *Server) closeListenersLocked() {
+	for lis := range s.listeners {
+		_ = lis.Close()
+		delete(s.listeners, lis)
+	}
+}
+
+// closeIdleConns 关闭已经度过 go away 等待期并且没有处理中请求的连接，全部关闭后返回 true
+func (s *Server) closeIdleConns() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for sc := range s.conns {
+		if sc.drained() {
+			_ = sc.cc.Close()
+			delete(s.conns, sc)
+		}
+	}
+	return len(s.conns) == 0
+}
+
+// sendGoAway 通知客户端该连接即将关闭，不要再发送新的请求
+func (s *Server) sendGoAway(sc *serverConn) {
+	sc.sending.Lock()
+	defer sc.sending.Unlock()
+	h := &codec.Header{Type: codec.MessageGoAway}
+	if err := sc.cc.Write(h, invalidRequest); err != nil {
+		log.Println("rpc server: write go away error: ", err)
+	}
+	sc.goAwayAt.CompareAndSwap(0, time.Now().UnixNano())
+}
+
+// drained 判断连接是否已经发送 go away 超过等待期，并且没有处理中的请求
+func (sc *serverConn) drained() bool {
+	at := sc.goAwayAt.Load()
+	return at != 0 && time.Since(time.Unix(0, at)) >= goAwayDrainPeriod && sc.active.Load() == 0
+}
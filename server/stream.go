@@ -0,0 +1,219 @@
+package server
+
+import (
+	"context"
+	"log"
+	"reflect"
+	"sync"
+
+	"github.com/qiancijun/minirpc/codec"
+	"github.com/qiancijun/minirpc/common"
+	"github.com/qiancijun/minirpc/service"
+)
+
+// serverStream 实现 service.ServerStream，两个方向都按窗口流控：
+// 发送受客户端归还的窗口限制，客户端消费得慢时 Send 阻塞；
+// 接收的消息最多缓存一个窗口，方法取走之后才把窗口归还给客户端
+type serverStream struct {
+	sc      *serverConn
+	id      uint64
+	method  string
+	window  uint32
+	ctx     context.Context // 请求的 context，取消后停止收发
+	callCtx context.Context // 交给方法的 context，带有认证与 trace 信息
+
+	mu      sync.Mutex
+	credits uint32        // 剩余可发送的消息条数
+	ready   chan struct{} // 窗口增加时通知等待中的 Send
+	sendSeq uint64
+
+	recvType  reflect.Type       // 客户端消息的类型，服务端流式方法为 nil
+	queue     chan reflect.Value // 读循环收到的消息，容量等于窗口
+	closeOnce sync.Once
+	in        reflect.Value // 传给方法的 <-chan A
+}
+
+var _ service.ServerStream = (*serverStream)(nil)
+
+func newServerStream(sc *serverConn, h *codec.Header, ctx context.Context, mtype *service.MethodType) *serverStream {
+	window := h.Window
+	if window == 0 {
+		window = common.DefaultStreamWindow
+	}
+	ss := &serverStream{
+		sc:      sc,
+		id:      h.StreamID,
+		method:  h.ServiceMethod,
+		window:  window,
+		ctx:     ctx,
+		callCtx: ctx,
+		credits: window,
+		ready:   make(chan struct{}, 1),
+	}
+	if mtype.ClientStreams() {
+		ss.recvType = mtype.ArgType.Elem()
+		ss.queue = make(chan reflect.Value, window)
+		ss.in = mtype.NewInChan()
+	}
+	return ss
+}
+
+func (ss *serverStream) Context() context.Context {
+	return ss.callCtx
+}
+
+func (ss *serverStream) Send(msg interface{}) error {
+	if err := ss.acquire(); err != nil {
+		return err
+	}
+	return ss.write(msg)
+}
+
+// write 不经过流控直接发送一条消息，客户端流方法的 reply 通过它发送
+func (ss *serverStream) write(msg interface{}) error {
+	ss.sendSeq++
+	h := &codec.Header{
+		ServiceMethod: ss.method,
+		Seq:           ss.sendSeq,
+		StreamID:      ss.id,
+		Type:          codec.MessageStream,
+	}
+	return ss.sc.write(h, msg)
+}
+
+// acquire 占用一条消息的窗口，窗口耗尽时等待客户端归还
+func (ss *serverStream) acquire() error {
+	for {
+		if err := ss.ctx.Err(); err != nil {
+			return err
+		}
+		ss.mu.Lock()
+		if ss.credits > 0 {
+			ss.credits--
+			ss.mu.Unlock()
+			return nil
+		}
+		ss.mu.Unlock()
+		select {
+		case <-ss.ctx.Done():
+		case <-ss.ready:
+		}
+	}
+}
+
+func (ss *serverStream) release(n uint32) {
+	ss.mu.Lock()
+	ss.credits += n
+	ss.mu.Unlock()
+	select {
+	case ss.ready <- struct{}{}:
+	default:
+	}
+}
+
+// relay 把读循环收到的消息逐条交给方法，每交付半个窗口就归还给客户端；
+// 客户端半关闭或调用结束时关闭 in
+func (ss *serverStream) relay() {
+	defer ss.in.Close()
+	var consumed uint32
+	for {
+		var v reflect.Value
+		var ok bool
+		select {
+		case v, ok = <-ss.queue:
+			if !ok {
+				return
+			}
+		case <-ss.ctx.Done():
+			return
+		}
+		chosen, _, _ := reflect.Select([]reflect.SelectCase{
+			{Dir: reflect.SelectSend, Chan: ss.in, Send: v.Elem()},
+			{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ss.ctx.Done())},
+		})
+		if chosen != 0 {
+			return
+		}
+		consumed++
+		if consumed >= max(ss.window/2, 1) {
+			ss.sc.sendWindowUpdate(ss.id, consumed)
+			consumed = 0
+		}
+	}
+}
+
+// receive 由读循环调用，缓存客户端发来的一条消息
+func (ss *serverStream) receive(v reflect.Value) bool {
+	select {
+	case ss.queue <- v:
+		return true
+	default:
+		return false
+	}
+}
+
+// closeRecv 处理客户端的半关闭
+func (ss *serverStream) closeRecv() {
+	ss.closeOnce.Do(func() { close(ss.queue) })
+}
+
+// registerStream 在读循环中登记流，保证随后到达的消息与窗口更新一定能找到它
+func (sc *serverConn) registerStream(req *request) *serverStream {
+	ss := newServerStream(sc, req.h, req.ctx, req.mtype)
+	sc.mu.Lock()
+	sc.streams[ss.id] = ss
+	sc.mu.Unlock()
+	if ss.recvType != nil {
+		go ss.relay()
+	}
+	return ss
+}
+
+func (sc *serverConn) stream(id uint64) *serverStream {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.streams[id]
+}
+
+// readStreamMessage 读取客户端在流上发送的消息，流已经结束时丢弃
+func (sc *serverConn) readStreamMessage(h *codec.Header) error {
+	ss := sc.stream(h.StreamID)
+	if ss == nil || ss.recvType == nil {
+		return sc.cc.ReadBody(nil)
+	}
+	v := reflect.New(ss.recvType)
+	if err := sc.cc.ReadBody(v.Interface()); err != nil {
+		return err
+	}
+	if !ss.receive(v) {
+		// 客户端没有遵守流控，取消这个流
+		log.Printf("rpc server: stream %d of %s exceeded its window\n", h.StreamID, ss.method)
+		sc.cancelCall(h.StreamID)
+	}
+	return nil
+}
+
+// closeStreamRecv 处理客户端的半关闭消息
+func (sc *serverConn) closeStreamRecv(id uint64) {
+	if ss := sc.stream(id); ss != nil && ss.recvType != nil {
+		ss.closeRecv()
+	}
+}
+
+// updateWindow 处理客户端的窗口更新消息
+func (sc *serverConn) updateWindow(id uint64, n uint32) {
+	if ss := sc.stream(id); ss != nil && n > 0 {
+		ss.release(n)
+	}
+}
+
+func (sc *serverConn) sendWindowUpdate(id uint64, n uint32) {
+	h := &codec.Header{
+		StreamID: id,
+		Type:     codec.MessageWindowUpdate,
+		Window:   n,
+	}
+	if err := sc.write(h, invalidRequest); err != nil {
+		log.Println("rpc server: send window update error: ", err)
+	}
+}
@@ -6,10 +6,12 @@ import (
 )
 
 type MethodType struct {
-	Method    reflect.Method
-	ArgType   reflect.Type
-	ReplyType reflect.Type
-	numCalls  uint64
+	Method      reflect.Method
+	ArgType     reflect.Type
+	ReplyType   reflect.Type
+	WithContext bool       // 第一个参数是否为 context.Context
+	Kind        MethodKind // 方法的形式，决定 ArgType 与 ReplyType 的含义
+	numCalls    uint64
 }
 
 func (m *MethodType) NumCalls() uint64 {
@@ -27,6 +29,27 @@ func (m *MethodType) NewArgv() reflect.Value {
 	return argv
 }
 
+// IsStream 判断是否为流式方法
+func (m *MethodType) IsStream() bool {
+	return m.Kind != UnaryMethod
+}
+
+// ClientStreams 判断客户端是否以流的形式发送参数，此时 ArgType 为 <-chan A
+func (m *MethodType) ClientStreams() bool {
+	return m.Kind == ClientStreamMethod || m.Kind == BidiStreamMethod
+}
+
+// ServerStreams 判断服务端是否以流的形式返回结果，此时 ReplyType 为 ServerStream
+func (m *MethodType) ServerStreams() bool {
+	return m.Kind == ServerStreamMethod || m.Kind == BidiStreamMethod
+}
+
+// NewInChan 为客户端流创建传给方法的通道，元素类型为 ArgType 的元素类型
+func (m *MethodType) NewInChan() reflect.Value {
+	return reflect.MakeChan(reflect.ChanOf(reflect.BothDir, m.ArgType.Elem()), 0)
+}
+
+// NewReplyv 创建方法的 reply，服务端流式方法没有 reply
 func (m *MethodType) NewReplyv() reflect.Value {
 	// reply 必须是一个指针类型
 	replyv := reflect.New(m.ReplyType.Elem())
@@ -37,4 +60,4 @@ func (m *MethodType) NewReplyv() reflect.Value {
 		replyv.Elem().Set(reflect.MakeSlice(m.ReplyType.Elem(), 0, 0))
 	}
 	return replyv
-}
\ No newline at end of file
+}
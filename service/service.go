@@ -1,12 +1,19 @@
 package service
 
 import (
+	"context"
+	"fmt"
 	"go/ast"
 	"log"
 	"reflect"
+	"runtime/debug"
 	"sync/atomic"
+
+	"github.com/qiancijun/minirpc/errs"
 )
 
+var typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+
 type Service struct {
 	Name   string
 	Typ    reflect.Type
@@ -31,27 +38,58 @@ func (s *Service) registerMethods() {
 	for i := 0; i < s.Typ.NumMethod(); i++ {
 		Method := s.Typ.Method(i)
 		mType := Method.Type
-		// 不满足一个 RPC 的方法
-		if mType.NumIn() != 3 || mType.NumOut() != 1 {
+		// 支持 M(args, *reply) error 与 M(ctx, args, *reply) error 两种形式，
+		// args 换成 <-chan A、reply 换成 ServerStream 即为流式方法，见 MethodKind
+		withContext := mType.NumIn() == 4 && mType.In(1) == typeOfContext
+		if (mType.NumIn() != 3 && !withContext) || mType.NumOut() != 1 {
+			continue
+		}
+		argIndex := 1
+		if withContext {
+			argIndex = 2
+		}
+		argType, replyType := mType.In(argIndex), mType.In(argIndex+1)
+		kind := methodKind(argType, replyType)
+		elemType := argType
+		if kind == ClientStreamMethod || kind == BidiStreamMethod {
+			elemType = argType.Elem()
+		} else if argType.Kind() == reflect.Chan {
+			// 只有 <-chan A 才是客户端流，其他通道无法编码
 			continue
 		}
-		argType, replyType := mType.In(1), mType.In(2)
-		if !isExportedOrBuiltinType(argType) || !isExportedOrBuiltinType(replyType) {
+		if !isExportedOrBuiltinType(elemType) || !isExportedOrBuiltinType(replyType) {
 			continue
 		}
 		s.Method[Method.Name] = &MethodType{
-			Method:    Method,
-			ArgType:   argType,
-			ReplyType: replyType,
+			Method:      Method,
+			ArgType:     argType,
+			ReplyType:   replyType,
+			WithContext: withContext,
+			Kind:        kind,
 		}
 		log.Printf("rpc server: register %s.%s\n", s.Name, Method.Name)
 	}
 }
 
-func (s *Service) Call(m *MethodType, argv, replyv reflect.Value) error {
+// Call 调用方法 m，ctx 只会传给以 context.Context 为第一个参数的方法，流式方法的 replyv 为流对象。
+// 方法中的 panic 会被恢复并转换为 CodeInternal 错误，只影响本次调用
+func (s *Service) Call(ctx context.Context, m *MethodType, argv, replyv reflect.Value) (err error) {
 	atomic.AddUint64(&m.numCalls, 1)
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("rpc server: %s.%s panic: %v\n%s", s.Name, m.Method.Name, r, debug.Stack())
+			err = errs.NewStatus(errs.CodeInternal, fmt.Sprintf("rpc server: %s.%s panic: %v", s.Name, m.Method.Name, r))
+		}
+	}()
 	f := m.Method.Func
-	returnValues := f.Call([]reflect.Value{s.Rcvr, argv, replyv})
+	in := []reflect.Value{s.Rcvr, argv, replyv}
+	if m.WithContext {
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		in = []reflect.Value{s.Rcvr, reflect.ValueOf(ctx), argv, replyv}
+	}
+	returnValues := f.Call(in)
 	// 检查 error
 	// 第一个返回值是 error 类型
 	if errInter := returnValues[0].Interface(); errInter != nil {
@@ -1,9 +1,12 @@
 package service
 
 import (
+	"context"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/qiancijun/minirpc/errs"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -21,6 +24,17 @@ func (f Foo) sum(args Args, reply *int) error {
 	return nil
 }
 
+type Bar int
+
+func (b Bar) Panic(args Args, reply *int) error {
+	panic("boom")
+}
+
+func (b Bar) Deadline(ctx context.Context, args Args, reply *bool) error {
+	_, *reply = ctx.Deadline()
+	return nil
+}
+
 func TestNewService(t *testing.T) {
 	var foo Foo
 	s := NewService(&foo)
@@ -29,6 +43,16 @@ func TestNewService(t *testing.T) {
 	assert.NotNil(t, mType)
 }
 
+func TestNewServiceWithContext(t *testing.T) {
+	var bar Bar
+	s := NewService(&bar)
+	assert.Equal(t, len(s.Method), 2)
+	mType := s.Method["Deadline"]
+	assert.NotNil(t, mType)
+	assert.True(t, mType.WithContext)
+	assert.Equal(t, reflect.TypeOf(Args{}), mType.ArgType)
+}
+
 func TestMethodTypeCall(t *testing.T) {
 	var foo Foo
 	s := NewService(&foo)
@@ -37,8 +61,130 @@ func TestMethodTypeCall(t *testing.T) {
 	argv := mType.NewArgv()
 	replyv := mType.NewReplyv()
 	argv.Set(reflect.ValueOf(Args{Num1: 1, Num2: 3}))
-	err := s.Call(mType, argv, replyv)
+	err := s.Call(context.Background(), mType, argv, replyv)
 	assert.NoError(t, err)
 	assert.Equal(t, *replyv.Interface().(*int), 4)
 	assert.Equal(t, mType.NumCalls(), uint64(1))
-}
\ No newline at end of file
+}
+
+func TestMethodTypeCallWithContext(t *testing.T) {
+	var bar Bar
+	s := NewService(&bar)
+	mType := s.Method["Deadline"]
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	argv := mType.NewArgv()
+	replyv := mType.NewReplyv()
+	err := s.Call(ctx, mType, argv, replyv)
+	assert.NoError(t, err)
+	assert.True(t, *replyv.Interface().(*bool))
+}
+
+func TestMethodTypeCallPanic(t *testing.T) {
+	var bar Bar
+	s := NewService(&bar)
+	mType := s.Method["Panic"]
+
+	err := s.Call(context.Background(), mType, mType.NewArgv(), mType.NewReplyv())
+	assert.Equal(t, errs.CodeInternal, errs.CodeOf(err))
+	assert.Contains(t, err.Error(), "boom")
+}
+
+type Baz int
+
+func (b Baz) Count(n int, stream ServerStream) error {
+	for i := 0; i < n; i++ {
+		if err := stream.Send(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b Baz) Sum(in <-chan int, reply *int) error {
+	for n := range in {
+		*reply += n
+	}
+	return nil
+}
+
+func (b Baz) Echo(ctx context.Context, in <-chan string, stream ServerStream) error {
+	for msg := range in {
+		if err := stream.Send(msg); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// 通道的发送方向不对，不是合法的方法
+func (b Baz) Bad(in chan<- int, reply *int) error { return nil }
+
+type sliceStream struct{ msgs []interface{} }
+
+func (s *sliceStream) Context() context.Context { return context.Background() }
+
+func (s *sliceStream) Send(msg interface{}) error {
+	s.msgs = append(s.msgs, msg)
+	return nil
+}
+
+func TestMethodTypeCallServerStream(t *testing.T) {
+	var baz Baz
+	s := NewService(&baz)
+	mType := s.Method["Count"]
+	assert.NotNil(t, mType)
+	assert.Equal(t, ServerStreamMethod, mType.Kind)
+	assert.True(t, mType.IsStream())
+
+	argv := mType.NewArgv()
+	argv.Set(reflect.ValueOf(3))
+	stream := &sliceStream{}
+	assert.NoError(t, s.Call(context.Background(), mType, argv, reflect.ValueOf(stream)))
+	assert.Equal(t, []interface{}{0, 1, 2}, stream.msgs)
+}
+
+func TestMethodTypeCallClientStream(t *testing.T) {
+	var baz Baz
+	s := NewService(&baz)
+	assert.NotContains(t, s.Method, "Bad")
+
+	mType := s.Method["Sum"]
+	assert.NotNil(t, mType)
+	assert.Equal(t, ClientStreamMethod, mType.Kind)
+	assert.True(t, mType.ClientStreams())
+	assert.False(t, mType.ServerStreams())
+
+	in := mType.NewInChan()
+	go func() {
+		for i := 1; i <= 4; i++ {
+			in.Send(reflect.ValueOf(i))
+		}
+		in.Close()
+	}()
+	replyv := mType.NewReplyv()
+	assert.NoError(t, s.Call(context.Background(), mType, in, replyv))
+	assert.Equal(t, 10, replyv.Elem().Interface())
+}
+
+func TestMethodTypeCallBidiStream(t *testing.T) {
+	var baz Baz
+	s := NewService(&baz)
+	mType := s.Method["Echo"]
+	assert.NotNil(t, mType)
+	assert.Equal(t, BidiStreamMethod, mType.Kind)
+	assert.True(t, mType.WithContext)
+	assert.True(t, mType.ClientStreams())
+	assert.True(t, mType.ServerStreams())
+
+	in := mType.NewInChan()
+	go func() {
+		in.Send(reflect.ValueOf("a"))
+		in.Send(reflect.ValueOf("b"))
+		in.Close()
+	}()
+	stream := &sliceStream{}
+	assert.NoError(t, s.Call(context.Background(), mType, in, reflect.ValueOf(stream)))
+	assert.Equal(t, []interface{}{"a", "b"}, stream.msgs)
+}
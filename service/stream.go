@@ -0,0 +1,51 @@
+package service
+
+import (
+	"context"
+	"reflect"
+)
+
+// ServerStream 是服务端流式方法向客户端连续发送消息的发送端，
+// 方法形如 M(args, stream ServerStream) error 或 M(ctx, args, stream ServerStream) error，
+// 方法返回即表示流结束，返回的错误会随结束消息发送给客户端
+type ServerStream interface {
+	// Context 返回本次调用的 context，客户端取消或超时后 Send 会返回错误
+	Context() context.Context
+	// Send 发送一条消息，客户端来不及接收时阻塞，不能并发调用
+	Send(msg interface{}) error
+}
+
+var typeOfServerStream = reflect.TypeOf((*ServerStream)(nil)).Elem()
+
+// MethodKind 区分一应一答的方法与流式方法：
+//
+//	UnaryMethod         M(args A, reply *R) error
+//	ServerStreamMethod  M(args A, stream ServerStream) error
+//	ClientStreamMethod  M(in <-chan A, reply *R) error
+//	BidiStreamMethod    M(in <-chan A, stream ServerStream) error
+//
+// 每种形式都可以在最前面加上 ctx context.Context。客户端调用 CloseSend 后 in 被关闭，
+// 调用被取消或超时时 in 同样会被关闭，方法需要通过 ctx.Err() 区分
+type MethodKind int
+
+const (
+	UnaryMethod MethodKind = iota
+	ServerStreamMethod
+	ClientStreamMethod
+	BidiStreamMethod
+)
+
+func methodKind(argType, replyType reflect.Type) MethodKind {
+	clientStreams := argType.Kind() == reflect.Chan && argType.ChanDir() == reflect.RecvDir
+	serverStreams := replyType == typeOfServerStream
+	switch {
+	case clientStreams && serverStreams:
+		return BidiStreamMethod
+	case clientStreams:
+		return ClientStreamMethod
+	case serverStreams:
+		return ServerStreamMethod
+	default:
+		return UnaryMethod
+	}
+}
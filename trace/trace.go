@@ -0,0 +1,326 @@
+package trace
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qiancijun/minirpc/errs"
+	"github.com/qiancijun/minirpc/metadata"
+)
+
+// TraceparentKey 是请求元数据中携带 W3C traceparent 的键
+const TraceparentKey = "traceparent"
+
+type TraceID [16]byte
+
+type SpanID [8]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+
+func (s SpanID) String() string { return hex.EncodeToString(s[:]) }
+
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+
+func (s SpanID) IsValid() bool { return s != SpanID{} }
+
+// SpanContext 是跨进程传递的 span 标识
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent 按 W3C Trace Context 格式编码，例如 00-<trace-id>-<span-id>-01
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ParseTraceparent 解析 W3C traceparent，格式不合法时返回错误
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(s, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, fmt.Errorf("trace: invalid traceparent %q", s)
+	}
+	// 版本 00 只有四段，更高的版本允许在后面追加字段
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, fmt.Errorf("trace: invalid traceparent %q", s)
+	}
+	var flags [1]byte
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, fmt.Errorf("trace: invalid trace id: %w", err)
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, fmt.Errorf("trace: invalid span id: %w", err)
+	}
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return sc, fmt.Errorf("trace: invalid trace flags: %w", err)
+	}
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("trace: invalid traceparent %q", s)
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, nil
+}
+
+type SpanKind int
+
+const (
+	KindInternal SpanKind = iota
+	KindClient
+	KindServer
+)
+
+func (k SpanKind) String() string {
+	switch k {
+	case KindClient:
+		return "client"
+	case KindServer:
+		return "server"
+	default:
+		return "internal"
+	}
+}
+
+// Event 是 span 生命周期内发生的事件，例如重试
+type Event struct {
+	Name       string
+	Time       time.Time
+	Attributes map[string]string
+}
+
+// Span 记录一次操作，结束后交给 Exporter
+type Span struct {
+	Name   string
+	Kind   SpanKind
+	Parent SpanID // 本地或远端的父 span，根 span 为零值
+	Start  time.Time
+	End    time.Time
+	Err    string    // 操作失败时的错误信息
+	Code   errs.Code // 操作结果对应的错误码
+
+	sc       SpanContext
+	exporter Exporter
+
+	mu         sync.Mutex
+	attributes map[string]string
+	events     []Event
+	ended      bool
+}
+
+// SpanContext 返回 span 的标识，nil span 返回零值
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attributes == nil {
+		s.attributes = make(map[string]string)
+	}
+	s.attributes[key] = value
+}
+
+// AddEvent 记录事件，kv 为交替出现的属性键值
+func (s *Span) AddEvent(name string, kv ...string) {
+	if s == nil {
+		return
+	}
+	e := Event{Name: name, Time: time.Now()}
+	if len(kv) > 0 {
+		e.Attributes = make(map[string]string, len(kv)/2)
+		for i := 0; i+1 < len(kv); i += 2 {
+			e.Attributes[kv[i]] = kv[i+1]
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, e)
+}
+
+// Attributes 返回属性的副本
+func (s *Span) Attributes() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attrs := make(map[string]string, len(s.attributes))
+	for k, v := range s.attributes {
+		attrs[k] = v
+	}
+	return attrs
+}
+
+// Events 返回事件的副本
+func (s *Span) Events() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Event(nil), s.events...)
+}
+
+// Finish 结束 span 并导出，err 不为 nil 时记录为失败；重复调用只有第一次生效
+func (s *Span) Finish(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = time.Now()
+	if err != nil {
+		s.Err = err.Error()
+		s.Code = errs.CodeOf(err)
+	}
+	s.mu.Unlock()
+	if s.sc.Sampled && s.exporter != nil {
+		s.exporter.Export(s)
+	}
+}
+
+// Exporter 接收结束的 span，需要支持并发调用
+type Exporter interface {
+	Export(span *Span)
+}
+
+// InMemoryExporter 将 span 保存在内存中，用于测试
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+func (e *InMemoryExporter) Export(span *Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, span)
+}
+
+// Spans 按结束顺序返回已导出的 span
+func (e *InMemoryExporter) Spans() []*Span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]*Span(nil), e.spans...)
+}
+
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+}
+
+// Tracer 创建 span，nil Tracer 不记录任何 span
+type Tracer struct {
+	exporter Exporter
+}
+
+func NewTracer(exporter Exporter) *Tracer {
+	return &Tracer{exporter: exporter}
+}
+
+type spanKey struct{}
+
+type remoteKey struct{}
+
+// Start 创建 span 并放入返回的 context，父 span 取自 ctx 中的本地 span 或远端 span
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	parent, ok := spanContextFromContext(ctx)
+	span := &Span{
+		Name:     name,
+		Kind:     kind,
+		Start:    time.Now(),
+		exporter: t.exporter,
+		sc:       SpanContext{SpanID: newSpanID(), Sampled: true},
+	}
+	if ok {
+		span.sc.TraceID = parent.TraceID
+		span.sc.Sampled = parent.Sampled
+		span.Parent = parent.SpanID
+	} else {
+		span.sc.TraceID = newTraceID()
+	}
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// SpanFromContext 返回 ctx 中当前的 span，没有时返回 nil
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// ContextWithRemoteSpanContext 记录从对端收到的 span 标识，之后创建的 span 以它为父 span
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+func spanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.sc, true
+	}
+	sc, ok := ctx.Value(remoteKey{}).(SpanContext)
+	return sc, ok && sc.IsValid()
+}
+
+// Inject 将 ctx 中当前 span 的标识写入发送元数据
+func Inject(ctx context.Context) context.Context {
+	span := SpanFromContext(ctx)
+	if span == nil {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, TraceparentKey, span.sc.Traceparent())
+}
+
+// Extract 读取请求元数据中的 traceparent，合法时作为远端父 span 记录到 ctx
+func Extract(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	tp := md.Get(TraceparentKey)
+	if tp == "" {
+		return ctx
+	}
+	sc, err := ParseTraceparent(tp)
+	if err != nil {
+		return ctx
+	}
+	return ContextWithRemoteSpanContext(ctx, sc)
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		binary.BigEndian.PutUint64(id[:8], rand.Uint64())
+		binary.BigEndian.PutUint64(id[8:], rand.Uint64())
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		binary.BigEndian.PutUint64(id[:], rand.Uint64())
+	}
+	return id
+}
@@ -0,0 +1,86 @@
+package trace
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/qiancijun/minirpc/errs"
+	"github.com/qiancijun/minirpc/metadata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tp := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceparent(tp)
+	require.NoError(t, err)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+	assert.True(t, sc.Sampled)
+	assert.Equal(t, tp, sc.Traceparent())
+
+	invalid := []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473g-00f067aa0ba902b7-01",
+	}
+	for _, s := range invalid {
+		_, err := ParseTraceparent(s)
+		assert.Error(t, err, s)
+	}
+	// 更高的版本允许追加字段
+	_, err = ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra")
+	assert.NoError(t, err)
+}
+
+func TestTracer_Start(t *testing.T) {
+	exporter := &InMemoryExporter{}
+	tracer := NewTracer(exporter)
+
+	ctx, root := tracer.Start(context.Background(), "root", KindInternal)
+	ctx, child := tracer.Start(ctx, "child", KindClient)
+	child.AddEvent("retry", "attempt", "2")
+	child.Finish(errs.ErrServiceNotFound)
+	child.Finish(nil)
+	root.Finish(nil)
+
+	spans := exporter.Spans()
+	require.Len(t, spans, 2)
+	assert.Equal(t, child, spans[0])
+	assert.Equal(t, root.SpanContext().TraceID, child.SpanContext().TraceID)
+	assert.Equal(t, root.SpanContext().SpanID, child.Parent)
+	assert.False(t, root.Parent.IsValid())
+	assert.Equal(t, errs.CodeNotFound, child.Code)
+	assert.Equal(t, "2", child.Events()[0].Attributes["attempt"])
+	assert.Equal(t, child, SpanFromContext(ctx))
+
+	// nil Tracer 不创建 span，对 nil span 的操作是安全的
+	var nilTracer *Tracer
+	_, span := nilTracer.Start(context.Background(), "noop", KindClient)
+	assert.Nil(t, span)
+	span.SetAttribute("k", "v")
+	span.Finish(errors.New("ignored"))
+}
+
+func TestInjectExtract(t *testing.T) {
+	tracer := NewTracer(&InMemoryExporter{})
+	ctx, client := tracer.Start(context.Background(), "client", KindClient)
+	ctx = Inject(ctx)
+	md, ok := metadata.FromOutgoingContext(ctx)
+	require.True(t, ok)
+
+	serverCtx := Extract(metadata.NewIncomingContext(context.Background(), md))
+	_, server := tracer.Start(serverCtx, "server", KindServer)
+	assert.Equal(t, client.SpanContext().TraceID, server.SpanContext().TraceID)
+	assert.Equal(t, client.SpanContext().SpanID, server.Parent)
+
+	// 非法的 traceparent 会被忽略
+	bad := metadata.NewIncomingContext(context.Background(), metadata.Pairs(TraceparentKey, "bad"))
+	_, span := tracer.Start(Extract(bad), "server", KindServer)
+	assert.False(t, span.Parent.IsValid())
+}
@@ -0,0 +1,86 @@
+package xclient
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/qiancijun/minirpc/registry"
+	"github.com/qiancijun/minirpc/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Who 返回服务端的编号
+func (f Foo) Who(_ int, reply *int) error {
+	*reply = int(f)
+	return nil
+}
+
+func TestMultiServersDiscovery_WeightedRoundRobin(t *testing.T) {
+	d := NewMultiServersDiscovery([]string{"a", "b", "c"})
+	d.UpdateWeights(map[string]int{"a": 5, "b": 1, "c": 1})
+	var picks []string
+	for i := 0; i < 14; i++ {
+		s, err := d.Get(WeightedRoundRobinSelect)
+		require.NoError(t, err)
+		picks = append(picks, s)
+	}
+	// 平滑加权轮询不会把权重大的服务端连续排在一起
+	assert.Equal(t, "a a b a c a a a a b a c a a", strings.Join(picks, " "))
+
+	// 没有权重或权重小于 1 的服务端按 1 计算
+	d.UpdateWeights(map[string]int{"a": 0})
+	counts := map[string]int{}
+	for i := 0; i < 30; i++ {
+		s, _ := d.Get(WeightedRoundRobinSelect)
+		counts[s]++
+	}
+	assert.Equal(t, map[string]int{"a": 10, "b": 10, "c": 10}, counts)
+
+	_, err := NewMultiServersDiscovery(nil).Get(WeightedRoundRobinSelect)
+	assert.Error(t, err)
+}
+
+func TestRegistryDiscovery_Weights(t *testing.T) {
+	reg := httptest.NewServer(registry.NewRegistry(0))
+	defer reg.Close()
+	registry.HeartBeatWithWeight(reg.URL, "tcp@a", 3, 0)
+	registry.HeartBeat(reg.URL, "tcp@b", 0)
+
+	d := NewGeeRegistryDiscovery(reg.URL, 0)
+	counts := map[string]int{}
+	for i := 0; i < 8; i++ {
+		s, err := d.Get(WeightedRoundRobinSelect)
+		require.NoError(t, err)
+		counts[s]++
+	}
+	assert.Equal(t, map[string]int{"tcp@a": 6, "tcp@b": 2}, counts)
+}
+
+func TestXClient_LeastOutstanding(t *testing.T) {
+	busy, idle := startServer(t, Foo(1), server.DefaultServerOption), startServer(t, Foo(2), server.DefaultServerOption)
+	xc := NewXClient(NewMultiServersDiscovery([]string{busy, idle}), LeastOutstandingSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	// 还没有连接时两台服务端都算空闲
+	seen := map[int]bool{}
+	for i := 0; i < 20; i++ {
+		var who int
+		require.NoError(t, xc.Call(context.Background(), "Foo.Who", 0, &who))
+		seen[who] = true
+	}
+	assert.Len(t, seen, 2)
+
+	c, err := xc.dial(context.Background(), busy)
+	require.NoError(t, err)
+	call := c.Go("Foo.Sleep", 200, new(int), nil)
+	for i := 0; i < 5; i++ {
+		var who int
+		require.NoError(t, xc.Call(context.Background(), "Foo.Who", 0, &who))
+		assert.Equal(t, 2, who, "calls should avoid the server with a pending call")
+	}
+	<-call.Done
+	require.NoError(t, call.Error)
+}
@@ -5,6 +5,19 @@ type SelectMode int
 const (
 	RandomSelect SelectMode = iota
 	RoundRobinSelect
+	// WeightedRoundRobinSelect spreads calls in proportion to the server weights,
+	// interleaving servers smoothly instead of sending bursts to the heaviest one.
+	WeightedRoundRobinSelect
+	// LeastOutstandingSelect picks the server with the fewest pending calls on the
+	// connections XClient holds. It is resolved by XClient, discoveries only see GetAll.
+	LeastOutstandingSelect
+	// ConsistentHashSelect routes calls carrying the same key, see WithHashKey, to the same
+	// server. Calls without a key go to a random server. It is resolved by XClient.
+	ConsistentHashSelect
+	// P2CSelect compares two random servers and picks the one with the lower latency
+	// EWMA scaled by its calls in flight, so slow or overloaded servers get less
+	// traffic. It is resolved by XClient from the results of its own calls.
+	P2CSelect
 )
 
 type Discovery interface {
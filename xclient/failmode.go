@@ -0,0 +1,166 @@
+package xclient
+
+import (
+	"context"
+	"math/rand/v2"
+	"strconv"
+
+	"github.com/qiancijun/minirpc/client"
+	"github.com/qiancijun/minirpc/metadata"
+	"github.com/qiancijun/minirpc/metrics"
+	"github.com/qiancijun/minirpc/trace"
+)
+
+// FailMode decides what Call does when an attempt fails with a connection error.
+// Failures to connect and errors proving the request was never executed, see
+// client.IsUnsentError, are retried for every method; other connection errors, see client.IsConnectionError,
+// only for methods declared idempotent with SetRetryConfig, since the server may
+// have run them already. Errors returned by the service method and errors caused
+// by the caller's context are never retried.
+type FailMode int
+
+const (
+	// Failfast returns the first error, it is the default.
+	Failfast FailMode = iota
+	// Failover retries on a server this call has not tried yet.
+	Failover
+	// Failtry retries on the same server, redialing if the connection was lost.
+	Failtry
+)
+
+// SetFailMode sets the fail mode and the number of retries allowed per call on top
+// of the first attempt. The call's context deadline bounds all attempts together,
+// no attempt starts once it has passed. Every retry carries client.RetryAttemptKey
+// in its metadata and the attempts of each call are recorded in the call_attempts
+// metric. It must be called before any call is made.
+func (xc *XClient) SetFailMode(mode FailMode, retries int) {
+	xc.failMode = mode
+	xc.retries = max(retries, 0)
+	xc.metrics = metrics.NewRPC(xc.registry(), "client")
+}
+
+// SetRetryConfig retries Call with client.RetryInterceptor(cfg) inside the interceptors
+// added by Use, and shares the idempotency declared in cfg, see client.RetryConfig.Idempotent,
+// with the fail mode. A call with a retry policy is retried by the policy only, with its
+// backoff, throttle and attempt accounting: the fail mode does not add retries of its own
+// and only picks the server of every attempt. Do not also add a retry interceptor with Use.
+// cfg.Metrics defaults to the registry of the dial option. It must be called before any call is made.
+func (xc *XClient) SetRetryConfig(cfg client.RetryConfig) {
+	if cfg.Metrics == nil {
+		cfg.Metrics = xc.registry()
+	}
+	xc.retryCfg = cfg
+	xc.retry = client.RetryInterceptor(cfg)
+}
+
+// callState follows the attempts of one Call, including those made by a retry
+// interceptor, so that each attempt picks its server according to the fail mode.
+type callState struct {
+	attempts int
+	last     string          // server of the previous attempt
+	tried    map[string]bool // servers tried by Failover
+}
+
+type callStateKey struct{}
+
+func newCallState() *callState {
+	return &callState{tried: make(map[string]bool)}
+}
+
+// invoke calls serviceMethod on the server chosen by the select mode and retries
+// connection errors according to the fail mode, unless a retry interceptor already
+// retries the call by policy. Every retry is recorded as an event on the span in ctx.
+func (xc *XClient) invoke(ctx context.Context, serviceMethod string, args, reply interface{}) (err error) {
+	st, ok := ctx.Value(callStateKey{}).(*callState)
+	if !ok {
+		st = newCallState()
+	}
+	retries := xc.retries
+	if xc.failMode == Failfast || client.RetriedByPolicy(ctx) {
+		// each attempt of the retry interceptor comes back here, only the choice of
+		// server follows the fail mode
+		retries = 0
+	} else if retries > 0 {
+		first := st.attempts
+		defer func() { xc.metrics.Attempts(serviceMethod, st.attempts-first) }()
+	}
+	for attempt := 1; ; attempt++ {
+		rpcAddr, serr := xc.nextServer(ctx, st)
+		if serr != nil {
+			if err != nil {
+				return err
+			}
+			return serr
+		}
+		actx := ctx
+		if st.last != "" {
+			trace.SpanFromContext(ctx).AddEvent("xclient.retry",
+				"attempt", strconv.Itoa(st.attempts+1), "failed_server", st.last, "server", rpcAddr)
+			if attempt > 1 {
+				actx = metadata.AppendToOutgoingContext(ctx, client.RetryAttemptKey, strconv.Itoa(attempt))
+			}
+		}
+		st.attempts++
+		st.last = rpcAddr
+
+		var dialed bool
+		dialed, err = xc.call(actx, rpcAddr, serviceMethod, args, reply)
+		if err == nil || attempt > retries || ctx.Err() != nil {
+			return err
+		}
+		if dialed && !xc.retryable(serviceMethod, err) {
+			return err
+		}
+	}
+}
+
+// nextServer picks the server of the next attempt: the select mode chooses the first
+// one, later attempts follow the fail mode.
+func (xc *XClient) nextServer(ctx context.Context, st *callState) (string, error) {
+	if st.last == "" {
+		return xc.selectServer(ctx)
+	}
+	switch xc.failMode {
+	case Failover:
+		st.tried[st.last] = true
+		return xc.failoverServer(ctx, st.tried)
+	case Failtry:
+		return st.last, nil
+	}
+	return xc.selectServer(ctx)
+}
+
+// retryable reports whether the fail mode may retry err returned by serviceMethod.
+func (xc *XClient) retryable(serviceMethod string, err error) bool {
+	return client.IsUnsentError(err) || client.IsConnectionError(err) && xc.retryCfg.Idempotent(serviceMethod)
+}
+
+// failoverServer asks the select mode for a server this call has not tried, so
+// balancing still applies, and falls back to a random untried server. Once every
+// server has been tried any of them may be picked again.
+func (xc *XClient) failoverServer(ctx context.Context, tried map[string]bool) (string, error) {
+	for i := 0; i < 3; i++ {
+		rpcAddr, err := xc.selectServer(ctx)
+		if err != nil {
+			return "", err
+		}
+		if !tried[rpcAddr] {
+			return rpcAddr, nil
+		}
+	}
+	servers, err := xc.d.GetAll()
+	if err != nil {
+		return "", err
+	}
+	var untried []string
+	for _, s := range servers {
+		if !tried[s] {
+			untried = append(untried, s)
+		}
+	}
+	if len(untried) == 0 {
+		clear(tried)
+		return xc.selectServer(ctx)
+	}
+	return untried[rand.IntN(len(untried))], nil
+}
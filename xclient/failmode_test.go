@@ -0,0 +1,330 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qiancijun/minirpc/client"
+	"github.com/qiancijun/minirpc/common"
+	"github.com/qiancijun/minirpc/errs"
+	"github.com/qiancijun/minirpc/metadata"
+	"github.com/qiancijun/minirpc/metrics"
+	"github.com/qiancijun/minirpc/server"
+	"github.com/qiancijun/minirpc/trace"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Counter 记录收到的调用次数
+type Counter struct {
+	calls atomic.Int32
+	crash func() // Crash 在返回响应前调用，用来模拟执行后断开的服务端
+}
+
+// Attempt 返回请求元数据中的尝试次数
+func (c *Counter) Attempt(ctx context.Context, _ int, reply *string) error {
+	c.calls.Add(1)
+	md, _ := metadata.FromIncomingContext(ctx)
+	*reply = md.Get(client.RetryAttemptKey)
+	return nil
+}
+
+func (c *Counter) Crash(_ int, reply *int) error {
+	*reply = int(c.calls.Add(1))
+	if c.crash != nil {
+		c.crash()
+	}
+	return nil
+}
+
+func (c *Counter) Inc(_ int, reply *int) error {
+	*reply = int(c.calls.Add(1))
+	return nil
+}
+
+func (c *Counter) Fail(_ int, reply *int) error {
+	c.calls.Add(1)
+	return errors.New("application error")
+}
+
+// flakyListener 直接关闭前 drop 个连接，模拟握手时断开的服务端
+type flakyListener struct {
+	net.Listener
+	drop atomic.Int32
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil || l.drop.Add(-1) < 0 {
+			return conn, err
+		}
+		_ = conn.Close()
+	}
+}
+
+// dropConns 返回 startServer 的监听器包装，直接关闭前 n 个连接
+func dropConns(n int) func(net.Listener) net.Listener {
+	return func(l net.Listener) net.Listener {
+		fl := &flakyListener{Listener: l}
+		fl.drop.Store(int32(n))
+		return fl
+	}
+}
+
+// crashListener 记录接受的连接，crash 关闭监听器与所有连接，模拟突然退出的服务端
+type crashListener struct {
+	net.Listener
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func (l *crashListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.mu.Lock()
+		l.conns = append(l.conns, conn)
+		l.mu.Unlock()
+	}
+	return conn, err
+}
+
+func (l *crashListener) crash() {
+	_ = l.Listener.Close()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, conn := range l.conns {
+		_ = conn.Close()
+	}
+}
+
+func (l *crashListener) wrap(inner net.Listener) net.Listener {
+	l.Listener = inner
+	return l
+}
+
+// deadAddr 返回一个没有服务端监听的地址
+func deadAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+	return "tcp@" + l.Addr().String()
+}
+
+func TestXClient_Failfast(t *testing.T) {
+	live := startServer(t, new(Counter), server.DefaultServerOption)
+	xc := NewXClient(NewMultiServersDiscovery([]string{deadAddr(t), live}), RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	failed := 0
+	for i := 0; i < 4; i++ {
+		if xc.Call(context.Background(), "Counter.Inc", 0, new(int)) != nil {
+			failed++
+		}
+	}
+	assert.Equal(t, 2, failed)
+}
+
+func TestXClient_Failover(t *testing.T) {
+	counter := new(Counter)
+	live := startServer(t, counter, server.DefaultServerOption)
+	dead := deadAddr(t)
+	exporter := &trace.InMemoryExporter{}
+	tracer := trace.NewTracer(exporter)
+	xc := NewXClient(NewMultiServersDiscovery([]string{dead, live}), RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetFailMode(Failover, 1)
+
+	for i := 0; i < 4; i++ {
+		ctx, span := tracer.Start(context.Background(), "test", trace.KindInternal)
+		require.NoError(t, xc.Call(ctx, "Counter.Inc", 0, new(int)))
+		span.Finish(nil)
+	}
+	assert.EqualValues(t, 4, counter.calls.Load())
+
+	// 每次转移到另一台服务端都会记录在调用方的 span 上
+	var retries []trace.Event
+	for _, span := range exporter.Spans() {
+		if span.Name == "test" {
+			retries = append(retries, span.Events()...)
+		}
+	}
+	// 轮询在转移时前进了一次，因此除了可能从可用服务端开始的第一次调用，每次调用都先选中不可用的服务端
+	require.GreaterOrEqual(t, len(retries), 3)
+	for _, e := range retries {
+		assert.Equal(t, "xclient.retry", e.Name)
+		assert.Equal(t, map[string]string{"attempt": "2", "failed_server": dead, "server": live}, e.Attributes)
+	}
+
+	// 服务端方法返回的错误不会重试
+	counter.calls.Store(0)
+	xc2 := NewXClient(NewMultiServersDiscovery([]string{live}), RoundRobinSelect, nil)
+	defer func() { _ = xc2.Close() }()
+	xc2.SetFailMode(Failover, 3)
+	assert.Error(t, xc2.Call(context.Background(), "Counter.Fail", 0, new(int)))
+	assert.EqualValues(t, 1, counter.calls.Load())
+
+	// 所有服务端都不可用时，重试次数用完后返回最后的错误
+	xc3 := NewXClient(NewMultiServersDiscovery([]string{dead, deadAddr(t)}), RandomSelect, nil)
+	defer func() { _ = xc3.Close() }()
+	xc3.SetFailMode(Failover, 3)
+	assert.Error(t, xc3.Call(context.Background(), "Counter.Inc", 0, new(int)))
+}
+
+func TestXClient_Failtry(t *testing.T) {
+	counter := new(Counter)
+	flaky := startServer(t, counter, server.DefaultServerOption, dropConns(2))
+	xc := NewXClient(NewMultiServersDiscovery([]string{flaky}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	// 前两个连接在握手时被关闭
+	assert.Error(t, xc.Call(context.Background(), "Counter.Inc", 0, new(int)))
+	xc.SetFailMode(Failtry, 1)
+	var reply int
+	require.NoError(t, xc.Call(context.Background(), "Counter.Inc", 0, &reply))
+	assert.Equal(t, 1, reply)
+	assert.EqualValues(t, 1, counter.calls.Load())
+
+	// ctx 结束后不再重试
+	xc2 := NewXClient(NewMultiServersDiscovery([]string{deadAddr(t)}), RandomSelect, nil)
+	defer func() { _ = xc2.Close() }()
+	xc2.SetFailMode(Failtry, 1000)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Error(t, xc2.Call(ctx, "Counter.Inc", 0, new(int)))
+}
+
+func TestXClient_FailoverAfterSend(t *testing.T) {
+	// crashing 在执行 Counter.Crash 后关闭所有连接，请求已经执行但客户端收不到响应
+	start := func() (crashing, live string, crashed, counter *Counter) {
+		cl := new(crashListener)
+		crashed, counter = &Counter{crash: cl.crash}, new(Counter)
+		crashing = startServer(t, crashed, server.DefaultServerOption, cl.wrap)
+		live = startServer(t, counter, server.DefaultServerOption)
+		return crashing, live, crashed, counter
+	}
+
+	t.Run("not idempotent", func(t *testing.T) {
+		crashing, live, crashed, counter := start()
+		xc := NewXClient(NewMultiServersDiscovery([]string{crashing, live}), RoundRobinSelect, nil)
+		defer func() { _ = xc.Close() }()
+		xc.SetFailMode(Failover, 1)
+
+		// 轮询保证两次调用中有一次落在 crashing 上，执行后断开的请求不会重试
+		failed := 0
+		for i := 0; i < 2; i++ {
+			if err := xc.Call(context.Background(), "Counter.Crash", 0, new(int)); err != nil {
+				assert.True(t, client.IsConnectionError(err), err)
+				failed++
+			}
+		}
+		assert.Equal(t, 1, failed)
+		assert.EqualValues(t, 1, crashed.calls.Load())
+		assert.EqualValues(t, 1, counter.calls.Load())
+
+		// 之后 crashing 无法连接，请求没有发出，任何方法都会转移到 live
+		for i := 0; i < 2; i++ {
+			require.NoError(t, xc.Call(context.Background(), "Counter.Crash", 0, new(int)))
+		}
+		assert.EqualValues(t, 3, counter.calls.Load())
+	})
+
+	t.Run("idempotent", func(t *testing.T) {
+		crashing, live, crashed, counter := start()
+		xc := NewXClient(NewMultiServersDiscovery([]string{crashing, live}), RoundRobinSelect, nil)
+		defer func() { _ = xc.Close() }()
+		xc.SetFailMode(Failover, 1)
+		xc.SetRetryConfig(client.RetryConfig{Policies: map[string]*client.RetryPolicy{
+			"Counter": {Idempotent: true},
+		}})
+
+		for i := 0; i < 2; i++ {
+			require.NoError(t, xc.Call(context.Background(), "Counter.Crash", 0, new(int)))
+		}
+		assert.EqualValues(t, 1, crashed.calls.Load())
+		assert.EqualValues(t, 2, counter.calls.Load())
+	})
+}
+
+func TestXClient_FailoverDeadline(t *testing.T) {
+	// 从不应答握手的服务端，拨号会一直等到 ConnectTimeout
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = l.Close() }()
+	xc := NewXClient(NewMultiServersDiscovery([]string{"tcp@" + l.Addr().String()}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetFailMode(Failtry, 1000)
+
+	// 所有尝试共用调用的截止时间，包括等待拨号的时间
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = xc.Call(ctx, "Counter.Inc", 0, new(int))
+	assert.ErrorIs(t, err, errs.ErrClientCallTimeout)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestXClient_RetryAccounting(t *testing.T) {
+	t.Run("fail mode", func(t *testing.T) {
+		live := startServer(t, new(Counter), server.DefaultServerOption)
+		reg := metrics.NewRegistry()
+		xc := NewXClient(NewMultiServersDiscovery([]string{deadAddr(t), live}), RoundRobinSelect, &common.Option{Metrics: reg})
+		defer func() { _ = xc.Close() }()
+		xc.SetFailMode(Failover, 1)
+
+		// 轮询保证两次调用中至少有一次先选中不可用的服务端，转移后的请求带有尝试次数
+		retried := 0
+		for i := 0; i < 2; i++ {
+			var reply string
+			require.NoError(t, xc.Call(context.Background(), "Counter.Attempt", 0, &reply))
+			if reply == "2" {
+				retried++
+			} else {
+				assert.Empty(t, reply)
+			}
+		}
+		assert.GreaterOrEqual(t, retried, 1)
+		attempts := reg.Histogram("minirpc_client_call_attempts", "", nil, "method").With("Counter.Attempt")
+		assert.Equal(t, uint64(2), attempts.Count())
+		assert.Equal(t, float64(2+retried), attempts.Sum())
+	})
+
+	t.Run("policy replaces fail mode retries", func(t *testing.T) {
+		reg := metrics.NewRegistry()
+		servers := []string{deadAddr(t), deadAddr(t), deadAddr(t), deadAddr(t)}
+		exporter := &trace.InMemoryExporter{}
+		tracer := trace.NewTracer(exporter)
+		xc := NewXClient(NewMultiServersDiscovery(servers), RandomSelect, &common.Option{Metrics: reg})
+		defer func() { _ = xc.Close() }()
+		xc.SetFailMode(Failover, 5)
+		xc.SetRetryConfig(client.RetryConfig{Policies: map[string]*client.RetryPolicy{
+			"Counter": {MaxAttempts: 2, InitialBackoff: time.Millisecond, Idempotent: true},
+		}})
+
+		// 策略的两次尝试不会再各自叠加 fail mode 的重试，但仍按照 Failover 换一台服务端
+		ctx, span := tracer.Start(context.Background(), "test", trace.KindInternal)
+		assert.Error(t, xc.Call(ctx, "Counter.Inc", 0, new(int)))
+		span.Finish(nil)
+		attempts := reg.Histogram("minirpc_client_call_attempts", "", nil, "method").With("Counter.Inc")
+		assert.Equal(t, uint64(1), attempts.Count())
+		assert.Equal(t, float64(2), attempts.Sum())
+
+		var retries []trace.Event
+		for _, span := range exporter.Spans() {
+			if span.Name == "test" {
+				for _, e := range span.Events() {
+					if e.Name == "xclient.retry" {
+						retries = append(retries, e)
+					}
+				}
+			}
+		}
+		require.Len(t, retries, 1)
+		assert.NotEqual(t, retries[0].Attributes["failed_server"], retries[0].Attributes["server"])
+	})
+}
@@ -0,0 +1,87 @@
+package xclient
+
+import (
+	"context"
+	"hash/fnv"
+	"slices"
+	"sort"
+	"strconv"
+)
+
+// DefaultHashReplicas is the number of virtual nodes every server gets on the hash ring.
+// More virtual nodes spread keys more evenly at the cost of a larger ring.
+const DefaultHashReplicas = 160
+
+type hashKey struct{}
+
+// WithHashKey returns a context whose calls are routed by key under ConsistentHashSelect,
+// calls with the same key reach the same server as long as the server list is unchanged.
+func WithHashKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, hashKey{}, key)
+}
+
+// HashKeyFromContext returns the key set by WithHashKey.
+func HashKeyFromContext(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(hashKey{}).(string)
+	return key, ok
+}
+
+// hashRing is a consistent hash ring with virtual nodes. Adding or removing a server
+// only moves the keys between it and its neighbours on the ring.
+type hashRing struct {
+	servers []string // sorted, used to detect changes of the server list
+	hashes  []uint32 // sorted virtual node hashes
+	owners  map[uint32]string
+}
+
+func newHashRing(servers []string, replicas int) *hashRing {
+	r := &hashRing{
+		servers: slices.Sorted(slices.Values(servers)),
+		owners:  make(map[uint32]string, len(servers)*replicas),
+	}
+	for _, s := range r.servers {
+		for i := 0; i < replicas; i++ {
+			h := hashOf(s + "#" + strconv.Itoa(i))
+			if _, ok := r.owners[h]; ok {
+				// keep the ring independent of the server order on collisions
+				continue
+			}
+			r.owners[h] = s
+			r.hashes = append(r.hashes, h)
+		}
+	}
+	slices.Sort(r.hashes)
+	return r
+}
+
+// matches reports whether the ring was built from the same servers.
+func (r *hashRing) matches(servers []string) bool {
+	return len(r.servers) == len(servers) && slices.Equal(r.servers, slices.Sorted(slices.Values(servers)))
+}
+
+// get returns the server owning key, the first virtual node clockwise from its hash.
+func (r *hashRing) get(key string) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	h := hashOf(key)
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.owners[r.hashes[i]]
+}
+
+// hashOf hashes s with FNV-1a followed by a finalizer, which spreads the
+// near-identical virtual node names evenly over the ring.
+func hashOf(s string) uint32 {
+	f := fnv.New32a()
+	_, _ = f.Write([]byte(s))
+	h := f.Sum32()
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
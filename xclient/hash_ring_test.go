@@ -0,0 +1,113 @@
+package xclient
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/qiancijun/minirpc/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ringOwners 返回 keys 在由 servers 构建的哈希环上的归属
+func ringOwners(servers []string, keys int) map[string]string {
+	r := newHashRing(servers, DefaultHashReplicas)
+	owners := make(map[string]string, keys)
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		owners[key] = r.get(key)
+	}
+	return owners
+}
+
+func TestHashRing_Balance(t *testing.T) {
+	servers := []string{"tcp@a", "tcp@b", "tcp@c", "tcp@d", "tcp@e"}
+	counts := map[string]int{}
+	for _, s := range ringOwners(servers, 10000) {
+		counts[s]++
+	}
+	require.Len(t, counts, len(servers))
+	for s, n := range counts {
+		// 每台服务端分到的 key 与平均值的偏差不超过 30%
+		assert.InDelta(t, 2000, n, 600, s)
+	}
+
+	// 服务端的顺序不影响 key 的归属
+	assert.Equal(t, ringOwners(servers, 1000), ringOwners([]string{"tcp@e", "tcp@d", "tcp@c", "tcp@b", "tcp@a"}, 1000))
+	assert.Empty(t, newHashRing(nil, DefaultHashReplicas).get("key"))
+}
+
+func TestHashRing_Remapping(t *testing.T) {
+	const keys = 10000
+	servers := []string{"tcp@a", "tcp@b", "tcp@c", "tcp@d", "tcp@e"}
+	before := ringOwners(servers, keys)
+
+	t.Run("add server", func(t *testing.T) {
+		after := ringOwners(append(servers[:len(servers):len(servers)], "tcp@f"), keys)
+		moved := 0
+		for key, s := range after {
+			if s != before[key] {
+				moved++
+				// 只有分给新服务端的 key 会移动
+				assert.Equal(t, "tcp@f", s)
+			}
+		}
+		// 理想情况下移动 1/6 的 key
+		t.Logf("moved %d of %d keys", moved, keys)
+		assert.InDelta(t, keys/6, moved, keys/12)
+	})
+
+	t.Run("remove server", func(t *testing.T) {
+		after := ringOwners(servers[1:], keys)
+		moved := 0
+		for key, s := range after {
+			if s != before[key] {
+				moved++
+				// 只有原来属于被移除服务端的 key 会移动
+				assert.Equal(t, "tcp@a", before[key])
+			}
+		}
+		t.Logf("moved %d of %d keys", moved, keys)
+		assert.InDelta(t, keys/5, moved, keys/10)
+	})
+}
+
+func TestXClient_ConsistentHash(t *testing.T) {
+	addrs := []string{startServer(t, Foo(1), server.DefaultServerOption), startServer(t, Foo(2), server.DefaultServerOption), startServer(t, Foo(3), server.DefaultServerOption)}
+	d := NewMultiServersDiscovery(addrs)
+	xc := NewXClient(d, ConsistentHashSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	who := func(key string) int {
+		var id int
+		require.NoError(t, xc.Call(WithHashKey(context.Background(), key), "Foo.Who", 0, &id))
+		return id
+	}
+
+	// 相同的 key 总是到达同一台服务端
+	owners := map[string]int{}
+	seen := map[int]bool{}
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		owners[key] = who(key)
+		seen[owners[key]] = true
+		assert.Equal(t, owners[key], who(key))
+	}
+	assert.Len(t, seen, 3)
+
+	// 移除一台服务端后，其余服务端上的 key 保持不变
+	d.Update(addrs[1:])
+	for key, id := range owners {
+		if id != 1 {
+			assert.Equal(t, id, who(key), key)
+		} else {
+			assert.NotEqual(t, 1, who(key), key)
+		}
+	}
+
+	// 没有 key 的调用随机选择服务端
+	var id int
+	require.NoError(t, xc.Call(context.Background(), "Foo.Who", 0, &id))
+	assert.Contains(t, []int{2, 3}, id)
+}
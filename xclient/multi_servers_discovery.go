@@ -14,6 +14,8 @@ type MultiServersDiscovery struct {
 	mu      sync.RWMutex
 	servers []string
 	index   int
+	weights map[string]int // servers missing from the map weigh 1
+	current map[string]int // smooth weighted round-robin state
 }
 
 // Get implements Discovery.
@@ -31,11 +33,48 @@ func (m *MultiServersDiscovery) Get(mode SelectMode) (string, error) {
 		s := m.servers[m.index%n]
 		m.index = (m.index + 1) % n
 		return s, nil
+	case WeightedRoundRobinSelect:
+		return m.nextWeighted(), nil
 	default:
 		return "", errs.ErrNotSupportedSelectMode
 	}
 }
 
+// nextWeighted implements smooth weighted round-robin: every server gains its weight,
+// the one with the highest current value is picked and pays back the total weight.
+func (m *MultiServersDiscovery) nextWeighted() string {
+	if m.current == nil {
+		m.current = make(map[string]int)
+	}
+	total, best := 0, ""
+	for _, s := range m.servers {
+		w := m.weight(s)
+		total += w
+		m.current[s] += w
+		if best == "" || m.current[s] > m.current[best] {
+			best = s
+		}
+	}
+	m.current[best] -= total
+	return best
+}
+
+func (m *MultiServersDiscovery) weight(server string) int {
+	if w, ok := m.weights[server]; ok && w > 0 {
+		return w
+	}
+	return 1
+}
+
+// UpdateWeights replaces the server weights used by WeightedRoundRobinSelect.
+// Servers without a weight, or with a weight below 1, weigh 1.
+func (m *MultiServersDiscovery) UpdateWeights(weights map[string]int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.weights = weights
+	m.current = nil
+}
+
 // GetAll implements Discovery.
 func (m *MultiServersDiscovery) GetAll() ([]string, error) {
 	m.mu.Lock()
@@ -55,6 +94,7 @@ func (m *MultiServersDiscovery) Update(servers []string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.servers = servers
+	m.current = nil
 	return nil
 }
 
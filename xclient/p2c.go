@@ -0,0 +1,141 @@
+package xclient
+
+import (
+	"math"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/qiancijun/minirpc/errs"
+)
+
+const (
+	// p2cDecay is the time constant of the latency EWMA, older samples lose
+	// weight as wall time passes rather than per call.
+	p2cDecay = time.Second
+	// p2cForcePick lets a server that lost every comparison for this long take
+	// the next call anyway, so a server that recovered gets sampled again.
+	p2cForcePick = time.Second
+	// p2cPenalty is the latency recorded for calls that failed because the
+	// server was unreachable or too slow to answer.
+	p2cPenalty = time.Second
+)
+
+// serverStats tracks the load of a single server for P2CSelect.
+type serverStats struct {
+	inflight atomic.Int64 // calls sent and not yet finished
+	lastPick atomic.Int64 // UnixNano of the last time the server was picked
+
+	mu         sync.Mutex
+	ewma       float64 // latency in nanoseconds, 0 until the first sample
+	lastSample time.Time
+}
+
+func newServerStats(now time.Time) *serverStats {
+	s := &serverStats{}
+	s.lastPick.Store(now.UnixNano())
+	return s
+}
+
+// begin marks the start of a call and returns the function that finishes it.
+func (s *serverStats) begin() func(err error) {
+	s.inflight.Add(1)
+	start := time.Now()
+	return func(err error) {
+		s.inflight.Add(-1)
+		rtt := time.Since(start)
+		switch errs.CodeOf(err) {
+		case errs.CodeCanceled:
+			// the caller gave up, this says nothing about the server
+			return
+		case errs.CodeUnavailable, errs.CodeDeadlineExceeded:
+			rtt = max(rtt, p2cPenalty)
+		}
+		s.observe(time.Now(), rtt)
+	}
+}
+
+// observe folds a latency sample into the EWMA, weighting the previous value
+// by how long ago it was updated.
+func (s *serverStats) observe(now time.Time, rtt time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lastSample.IsZero() {
+		s.ewma = float64(rtt)
+	} else {
+		w := math.Exp(-float64(now.Sub(s.lastSample)) / float64(p2cDecay))
+		s.ewma = s.ewma*w + float64(rtt)*(1-w)
+	}
+	s.lastSample = now
+}
+
+// load estimates how long a new call would take, the latency scaled by the
+// calls already in flight. Servers without samples look idle so they get probed.
+func (s *serverStats) load() float64 {
+	s.mu.Lock()
+	ewma := s.ewma
+	s.mu.Unlock()
+	return (ewma + 1) * float64(s.inflight.Load()+1)
+}
+
+// stats returns the load statistics of rpcAddr, creating them on first use.
+func (xc *XClient) stats(rpcAddr string) *serverStats {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	s, ok := xc.serverStats[rpcAddr]
+	if !ok {
+		s = newServerStats(time.Now())
+		xc.serverStats[rpcAddr] = s
+	}
+	return s
+}
+
+// pruneStats drops the statistics of servers discovery no longer returns, checking
+// the list only when it differs from the one seen last time.
+func (xc *XClient) pruneStats(servers []string) {
+	servers = slices.Sorted(slices.Values(servers))
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	if slices.Equal(xc.statsServers, servers) {
+		return
+	}
+	xc.statsServers = servers
+	for s := range xc.serverStats {
+		if _, found := slices.BinarySearch(servers, s); !found {
+			delete(xc.serverStats, s)
+		}
+	}
+}
+
+// p2c picks two distinct servers at random and returns the one with the lower load.
+func (xc *XClient) p2c() (string, error) {
+	servers, err := xc.d.GetAll()
+	if err != nil {
+		return "", err
+	}
+	xc.pruneStats(servers)
+	switch len(servers) {
+	case 0:
+		return "", errs.ErrNoAvailableServers
+	case 1:
+		return servers[0], nil
+	}
+	i := rand.IntN(len(servers))
+	j := rand.IntN(len(servers) - 1)
+	if j >= i {
+		j++
+	}
+	pick, other := servers[i], servers[j]
+	ps, os := xc.stats(pick), xc.stats(other)
+	if os.load() < ps.load() {
+		pick, other, ps, os = other, pick, os, ps
+	}
+	now := time.Now().UnixNano()
+	if now-os.lastPick.Load() > int64(p2cForcePick) {
+		pick, ps = other, os
+	}
+	ps.lastPick.Store(now)
+	return pick, nil
+}
@@ -0,0 +1,126 @@
+package xclient
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qiancijun/minirpc/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Slow 每次调用都会休眠 delay 后返回自己的编号
+type Slow struct {
+	id    int
+	delay time.Duration
+}
+
+func (s *Slow) Who(_ int, reply *int) error {
+	time.Sleep(s.delay)
+	*reply = s.id
+	return nil
+}
+
+func TestServerStats(t *testing.T) {
+	now := time.Now()
+	s := newServerStats(now)
+	// 没有样本的服务端看起来是空闲的
+	assert.Equal(t, 1.0, s.load())
+
+	s.observe(now, 10*time.Millisecond)
+	assert.InDelta(t, float64(10*time.Millisecond), s.ewma, 1)
+	// 新样本的权重随间隔时间增加
+	s.observe(now.Add(p2cDecay), 0)
+	assert.InDelta(t, float64(10*time.Millisecond)/2.718, s.ewma, float64(time.Millisecond)/10)
+
+	// 进行中的调用成倍增加负载
+	before := s.load()
+	s.inflight.Add(2)
+	assert.InDelta(t, 3*before, s.load(), 1)
+	s.inflight.Add(-2)
+
+	// 调用方取消的调用不计入延迟
+	done := s.begin()
+	done(context.Canceled)
+	assert.Zero(t, s.inflight.Load())
+	assert.Equal(t, now.Add(p2cDecay), s.lastSample)
+}
+
+func TestXClient_P2C(t *testing.T) {
+	t.Parallel()
+	fast1 := startServer(t, &Slow{id: 1, delay: time.Millisecond}, server.DefaultServerOption)
+	fast2 := startServer(t, &Slow{id: 2, delay: time.Millisecond}, server.DefaultServerOption)
+	slow := startServer(t, &Slow{id: 3, delay: 30 * time.Millisecond}, server.DefaultServerOption)
+	xc := NewXClient(NewMultiServersDiscovery([]string{fast1, fast2, slow}), P2CSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	// 8 个并发调用方共发起 400 次调用，统计每台服务端处理的次数
+	var mu sync.Mutex
+	counts := map[int]int{}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				var who int
+				if !assert.NoError(t, xc.Call(context.Background(), "Slow.Who", 0, &who)) {
+					return
+				}
+				mu.Lock()
+				counts[who]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	t.Logf("calls per server: %v", counts)
+	assert.Less(t, counts[3], 400/10, "the slow server should receive little traffic")
+	assert.Greater(t, counts[1], 400/3)
+	assert.Greater(t, counts[2], 400/3)
+}
+
+func TestXClient_P2CRecovery(t *testing.T) {
+	xc := NewXClient(NewMultiServersDiscovery([]string{"tcp@a", "tcp@b"}), P2CSelect, nil)
+	defer func() { _ = xc.Close() }()
+	now := time.Now()
+	xc.stats("tcp@a").observe(now, time.Millisecond)
+	xc.stats("tcp@b").observe(now, time.Second)
+
+	for i := 0; i < 10; i++ {
+		s, err := xc.p2c()
+		require.NoError(t, err)
+		assert.Equal(t, "tcp@a", s)
+	}
+
+	// 很久没有被选中的服务端会被强制选中一次，以便重新测量它的延迟
+	xc.stats("tcp@b").lastPick.Store(now.Add(-2 * p2cForcePick).UnixNano())
+	s, err := xc.p2c()
+	require.NoError(t, err)
+	assert.Equal(t, "tcp@b", s)
+	s, err = xc.p2c()
+	require.NoError(t, err)
+	assert.Equal(t, "tcp@a", s)
+
+	_, err = NewXClient(NewMultiServersDiscovery(nil), P2CSelect, nil).p2c()
+	assert.Error(t, err)
+}
+
+func TestXClient_P2CPrunesStats(t *testing.T) {
+	d := NewMultiServersDiscovery([]string{"tcp@a", "tcp@b"})
+	xc := NewXClient(d, P2CSelect, nil)
+	defer func() { _ = xc.Close() }()
+	_, err := xc.p2c()
+	require.NoError(t, err)
+	assert.Len(t, xc.serverStats, 2)
+
+	// 服务端列表变化后，不再存在的服务端的统计会被删除
+	require.NoError(t, d.Update([]string{"tcp@b", "tcp@c"}))
+	_, err = xc.p2c()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"tcp@b", "tcp@c"}, slices.Collect(maps.Keys(xc.serverStats)))
+}
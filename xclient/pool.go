@@ -0,0 +1,240 @@
+package xclient
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/qiancijun/minirpc/client"
+	"github.com/qiancijun/minirpc/errs"
+)
+
+// PoolOption configures the connections XClient keeps for every server address.
+type PoolOption struct {
+	MinConns    int           // connections kept open once the address has been used
+	MaxConns    int           // upper bound of connections per address, values below 1 mean 1
+	IdleTimeout time.Duration // connections above MinConns without calls for this long are closed, 0 keeps them
+}
+
+// DefaultPoolOption keeps a single connection per address.
+var DefaultPoolOption = PoolOption{MaxConns: 1}
+
+type pooledClient struct {
+	*client.Client
+	lastUsed atomic.Int64 // UnixNano of the last time the connection was handed out
+}
+
+// pool holds the connections to a single address.
+type pool struct {
+	opt  PoolOption
+	dial func() (*client.Client, error)
+
+	mu       sync.Mutex
+	conns    []*pooledClient
+	draining []*pooledClient // got a go away, kept until their pending calls finish
+	first    *dialCall       // dial shared by the callers that found the pool empty
+	dialing  int             // dials in progress, including first
+	closed   bool
+}
+
+// dialCall is a dial in progress, done is closed once pc or err is set.
+type dialCall struct {
+	done chan struct{}
+	pc   *pooledClient
+	err  error
+}
+
+func newPool(opt PoolOption, dial func() (*client.Client, error)) *pool {
+	return &pool{opt: opt, dial: dial}
+}
+
+// get returns the connection with the fewest pending calls. Only an empty pool makes the
+// caller wait for a dial, and concurrent callers share it; when every connection is busy and
+// the pool is below MaxConns, new connections are dialed in the background so the current
+// call does not wait for them. Dials never hold p.mu, an unreachable address does not block
+// size, pending or the other callers. A caller stops waiting once ctx is done, the dial
+// goes on and its connection is kept for later calls.
+func (p *pool) get(ctx context.Context) (*client.Client, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, errs.ErrShutdown
+	}
+	p.removeUnavailableLocked()
+
+	var best *pooledClient
+	bestPending := 0
+	for _, pc := range p.conns {
+		if n := pc.Pending(); best == nil || n < bestPending {
+			best, bestPending = pc, n
+		}
+	}
+	if best == nil {
+		call := p.dialFirstLocked()
+		p.mu.Unlock()
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil, errs.ErrClientCallTimeout
+			}
+			return nil, errs.ErrClientCallCanceled
+		}
+		if call.err != nil {
+			return nil, call.err
+		}
+		p.mu.Lock()
+		best = call.pc
+	}
+
+	total := len(p.conns) + p.dialing
+	grow := p.opt.MinConns - total
+	if grow <= 0 && bestPending > 0 && total < p.opt.MaxConns {
+		grow = 1
+	}
+	for i := 0; i < grow; i++ {
+		p.dialing++
+		go p.grow()
+	}
+	p.mu.Unlock()
+	best.lastUsed.Store(time.Now().UnixNano())
+	return best.Client, nil
+}
+
+// dialFirstLocked returns the dial in progress for an empty pool, starting one if needed.
+func (p *pool) dialFirstLocked() *dialCall {
+	if p.first == nil {
+		p.first = &dialCall{done: make(chan struct{})}
+		p.dialing++
+		go p.dialFirst(p.first)
+	}
+	return p.first
+}
+
+func (p *pool) dialFirst(call *dialCall) {
+	c, err := p.dial()
+	p.mu.Lock()
+	p.dialing--
+	p.first = nil
+	switch {
+	case err != nil:
+		call.err = err
+	case p.closed:
+		_ = c.Close()
+		call.err = errs.ErrShutdown
+	default:
+		call.pc = p.addLocked(c)
+	}
+	p.mu.Unlock()
+	close(call.done)
+}
+
+func (p *pool) grow() {
+	c, err := p.dial()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dialing--
+	if err != nil {
+		log.Println("rpc xclient: pool dial error: ", err)
+		return
+	}
+	if p.closed || len(p.conns) >= p.opt.MaxConns {
+		_ = c.Close()
+		return
+	}
+	p.addLocked(c)
+}
+
+func (p *pool) addLocked(c *client.Client) *pooledClient {
+	pc := &pooledClient{Client: c}
+	pc.lastUsed.Store(time.Now().UnixNano())
+	p.conns = append(p.conns, pc)
+	return pc
+}
+
+// removeUnavailableLocked stops handing out connections that no longer accept calls.
+// A connection that got a go away is closed only once its pending calls are done or
+// it has dropped, closing it earlier would fail the calls the server is finishing.
+func (p *pool) removeUnavailableLocked() {
+	conns := p.conns[:0]
+	for _, pc := range p.conns {
+		switch {
+		case pc.AcceptingCalls():
+			conns = append(conns, pc)
+		case pc.IsAvailable() && pc.Pending() > 0:
+			p.draining = append(p.draining, pc)
+		default:
+			_ = pc.Close()
+		}
+	}
+	clear(p.conns[len(conns):])
+	p.conns = conns
+
+	draining := p.draining[:0]
+	for _, pc := range p.draining {
+		if pc.IsAvailable() && pc.Pending() > 0 {
+			draining = append(draining, pc)
+		} else {
+			_ = pc.Close()
+		}
+	}
+	clear(p.draining[len(draining):])
+	p.draining = draining
+}
+
+// evictIdle closes connections above MinConns that have no pending calls and
+// have not been handed out within IdleTimeout.
+func (p *pool) evictIdle(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.removeUnavailableLocked()
+	deadline := now.Add(-p.opt.IdleTimeout).UnixNano()
+	conns := p.conns[:0]
+	for i, pc := range p.conns {
+		keep := len(conns)+len(p.conns)-i <= p.opt.MinConns
+		if keep || pc.lastUsed.Load() > deadline || pc.Pending() > 0 {
+			conns = append(conns, pc)
+		} else {
+			_ = pc.Close()
+		}
+	}
+	clear(p.conns[len(conns):])
+	p.conns = conns
+}
+
+// pending returns the number of pending calls over all connections, including
+// the draining ones.
+func (p *pool) pending() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for _, pc := range p.conns {
+		n += pc.Pending()
+	}
+	for _, pc := range p.draining {
+		n += pc.Pending()
+	}
+	return n
+}
+
+// size returns the number of open connections.
+func (p *pool) size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.conns)
+}
+
+func (p *pool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for _, pc := range p.conns {
+		_ = pc.Close()
+	}
+	for _, pc := range p.draining {
+		_ = pc.Close()
+	}
+	p.conns, p.draining = nil, nil
+}
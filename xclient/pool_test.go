@@ -0,0 +1,184 @@
+package xclient
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qiancijun/minirpc/client"
+	"github.com/qiancijun/minirpc/errs"
+	"github.com/qiancijun/minirpc/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Sleep 休眠 ms 毫秒
+func (f Foo) Sleep(ms int, reply *int) error {
+	time.Sleep(time.Duration(ms) * time.Millisecond)
+	*reply = ms
+	return nil
+}
+
+func (xc *XClient) poolSize(rpcAddr string) int {
+	xc.mu.Lock()
+	p := xc.pools[rpcAddr]
+	xc.mu.Unlock()
+	if p == nil {
+		return 0
+	}
+	return p.size()
+}
+
+func TestXClient_Pool(t *testing.T) {
+	t.Parallel()
+	addr := startServer(t, new(Foo), server.DefaultServerOption)
+
+	t.Run("default keeps one connection", func(t *testing.T) {
+		xc := NewXClient(NewMultiServersDiscovery([]string{addr}), RoundRobinSelect, nil)
+		defer func() { _ = xc.Close() }()
+		callConcurrently(t, xc, 8, 50)
+		assert.Equal(t, 1, xc.poolSize(addr))
+	})
+
+	t.Run("grows under load up to max", func(t *testing.T) {
+		xc := NewXClient(NewMultiServersDiscovery([]string{addr}), RoundRobinSelect, nil)
+		defer func() { _ = xc.Close() }()
+		xc.SetPoolOption(PoolOption{MaxConns: 4})
+		for i := 0; i < 3; i++ {
+			callConcurrently(t, xc, 16, 30)
+		}
+		assert.Equal(t, 4, xc.poolSize(addr))
+	})
+
+	t.Run("least pending", func(t *testing.T) {
+		xc := NewXClient(NewMultiServersDiscovery([]string{addr}), RoundRobinSelect, nil)
+		defer func() { _ = xc.Close() }()
+		xc.SetPoolOption(PoolOption{MinConns: 2, MaxConns: 2})
+		require.NoError(t, xc.Call(context.Background(), "Foo.Sleep", 0, new(int)))
+		require.Eventually(t, func() bool { return xc.poolSize(addr) == 2 }, time.Second, 5*time.Millisecond)
+
+		// 第一个连接忙时，下一个调用会选择空闲的连接
+		busy, err := xc.dial(context.Background(), addr)
+		require.NoError(t, err)
+		done := busy.Go("Foo.Sleep", 100, new(int), nil)
+		idle, err := xc.dial(context.Background(), addr)
+		require.NoError(t, err)
+		assert.NotSame(t, busy, idle)
+		<-done.Done
+	})
+
+	t.Run("evicts idle connections", func(t *testing.T) {
+		xc := NewXClient(NewMultiServersDiscovery([]string{addr}), RoundRobinSelect, nil)
+		defer func() { _ = xc.Close() }()
+		xc.SetPoolOption(PoolOption{MinConns: 1, MaxConns: 4, IdleTimeout: 50 * time.Millisecond})
+		for i := 0; i < 3; i++ {
+			callConcurrently(t, xc, 16, 30)
+		}
+		assert.Greater(t, xc.poolSize(addr), 1)
+		assert.Eventually(t, func() bool { return xc.poolSize(addr) == 1 }, time.Second, 10*time.Millisecond)
+		require.NoError(t, xc.Call(context.Background(), "Foo.Sleep", 0, new(int)))
+	})
+}
+
+func TestPool_DialOutsideLock(t *testing.T) {
+	t.Parallel()
+	addr := startServer(t, new(Foo), server.DefaultServerOption)
+	release := make(chan struct{})
+	var dials atomic.Int32
+	p := newPool(DefaultPoolOption, func() (*client.Client, error) {
+		dials.Add(1)
+		<-release
+		return client.XDial(addr)
+	})
+	defer p.close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := p.get(context.Background())
+			assert.NoError(t, err)
+		}()
+	}
+	// 拨号进行中时 size 与 pending 不会被阻塞，并发的调用共用同一次拨号
+	require.Eventually(t, func() bool { return dials.Load() == 1 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, 0, p.size())
+	assert.Equal(t, 0, p.pending())
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), dials.Load())
+	assert.Equal(t, 1, p.size())
+}
+
+func TestXClient_ShutdownDrain(t *testing.T) {
+	t.Parallel()
+	s := server.NewServer()
+	require.NoError(t, s.Register(new(Foo)))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go s.Accept(l, server.DefaultServerOption)
+	xc := NewXClient(NewMultiServersDiscovery([]string{"tcp@" + l.Addr().String()}), RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	require.NoError(t, xc.Call(context.Background(), "Foo.Sleep", 0, new(int)))
+
+	inflight := make(chan error, 1)
+	go func() { inflight <- xc.Call(context.Background(), "Foo.Sleep", 300, new(int)) }()
+	time.Sleep(50 * time.Millisecond)
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(context.Background()) }()
+	time.Sleep(50 * time.Millisecond)
+
+	// 收到 go away 的连接不再用于新的调用，新调用会重新拨号，但旧连接会等处理中的调用完成后才关闭
+	err = xc.Call(context.Background(), "Foo.Sleep", 0, new(int))
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, errs.ErrShutdown)
+	assert.NoError(t, <-inflight)
+	assert.NoError(t, <-shutdown)
+}
+
+// callConcurrently 同时发起 n 个休眠 ms 毫秒的调用
+func callConcurrently(t *testing.T, xc *XClient, n, ms int) {
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var reply int
+			assert.NoError(t, xc.Call(context.Background(), "Foo.Sleep", ms, &reply))
+		}()
+	}
+	wg.Wait()
+}
+
+func benchmarkXClient(b *testing.B, opt PoolOption) {
+	addr := startServer(b, new(Foo), server.DefaultServerOption)
+	xc := NewXClient(NewMultiServersDiscovery([]string{addr}), RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetPoolOption(opt)
+	args := &Args{Num1: 1, Num2: 2}
+	require.NoError(b, xc.Call(context.Background(), "Foo.Sum", args, new(int)))
+
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var reply int
+		for pb.Next() {
+			if err := xc.Call(context.Background(), "Foo.Sum", args, &reply); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkXClient_SingleConn(b *testing.B) {
+	benchmarkXClient(b, DefaultPoolOption)
+}
+
+func BenchmarkXClient_Pool(b *testing.B) {
+	benchmarkXClient(b, PoolOption{MinConns: 4, MaxConns: 8})
+}
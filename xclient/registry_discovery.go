@@ -3,6 +3,7 @@ package xclient
 import (
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -32,6 +33,7 @@ func (r *MiniRegisterDiscovery) Update(servers []string) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	r.servers = servers
+	r.current = nil
 	r.lastUpdate = time.Now()
 	return nil
 }
@@ -55,6 +57,8 @@ func (r *MiniRegisterDiscovery) Refresh() error {
 			r.servers = append(r.servers, strings.TrimSpace(server))
 		}
 	}
+	r.weights = parseWeights(resp.Header.Get("X-Minirpc-Weights"))
+	r.current = nil
 	r.lastUpdate = time.Now()
 	return nil
 }
@@ -72,3 +76,18 @@ func (r *MiniRegisterDiscovery) GetAll() ([]string, error) {
 	}
 	return r.MultiServersDiscovery.GetAll()
 }
+
+// parseWeights parses the "addr=weight,..." list sent by registries that know server weights.
+func parseWeights(header string) map[string]int {
+	weights := make(map[string]int)
+	for _, item := range strings.Split(header, ",") {
+		i := strings.LastIndex(item, "=")
+		if i < 0 {
+			continue
+		}
+		if w, err := strconv.Atoi(strings.TrimSpace(item[i+1:])); err == nil {
+			weights[strings.TrimSpace(item[:i])] = w
+		}
+	}
+	return weights
+}
@@ -3,11 +3,17 @@ package xclient
 import (
 	"context"
 	"io"
+	"math/rand/v2"
 	"reflect"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/qiancijun/minirpc/client"
 	"github.com/qiancijun/minirpc/common"
+	"github.com/qiancijun/minirpc/errs"
+	"github.com/qiancijun/minirpc/metrics"
+	"github.com/qiancijun/minirpc/trace"
 )
 
 type XClient struct {
@@ -15,7 +21,21 @@ type XClient struct {
 	mode    SelectMode
 	opt     *common.Option
 	mu      sync.Mutex
-	clients map[string]*client.Client
+	pools   map[string]*pool
+	poolOpt PoolOption
+	stop    chan struct{} // closed by Close to stop idle eviction
+	ring    *hashRing     // built lazily for ConsistentHashSelect
+
+	serverStats  map[string]*serverStats // per server load fed by call, used by P2CSelect
+	statsServers []string                // sorted servers serverStats was last pruned against
+
+	failMode FailMode
+	retries  int
+	retryCfg client.RetryConfig            // declares the methods the fail mode may retry after the request was sent
+	retry    client.UnaryClientInterceptor // retries Call as retryCfg declares, nil without a config
+	metrics  *metrics.RPC                  // records the attempts made by the fail mode
+
+	interceptors []client.UnaryClientInterceptor
 }
 
 func NewXClient(d Discovery, mode SelectMode, opt *common.Option) *XClient {
@@ -23,7 +43,21 @@ func NewXClient(d Discovery, mode SelectMode, opt *common.Option) *XClient {
 		d:       d,
 		mode:    mode,
 		opt:     opt,
-		clients: make(map[string]*client.Client),
+		pools:   make(map[string]*pool),
+		poolOpt: DefaultPoolOption,
+		stop:    make(chan struct{}),
+
+		serverStats: make(map[string]*serverStats),
+	}
+}
+
+// SetPoolOption configures the connections kept per server address.
+// It must be called before any call is made.
+func (xc *XClient) SetPoolOption(opt PoolOption) {
+	opt.MaxConns = max(opt.MaxConns, opt.MinConns, 1)
+	xc.poolOpt = opt
+	if opt.IdleTimeout > 0 {
+		go xc.evictIdle(opt.IdleTimeout)
 	}
 }
 
@@ -31,59 +65,229 @@ func NewXClient(d Discovery, mode SelectMode, opt *common.Option) *XClient {
 func (x *XClient) Close() error {
 	x.mu.Lock()
 	defer x.mu.Unlock()
-	for key, client := range x.clients {
-		_ = client.Close()
-		delete(x.clients, key)
+	select {
+	case <-x.stop:
+	default:
+		close(x.stop)
+	}
+	for key, p := range x.pools {
+		p.close()
+		delete(x.pools, key)
 	}
 	return nil
 }
 
 var _ io.Closer = (*XClient)(nil)
 
-func (xc *XClient) dial(rpcAddr string) (*client.Client, error) {
+// dial returns the least busy connection to rpcAddr from its pool, waiting for a new
+// connection no longer than ctx allows.
+func (xc *XClient) dial(ctx context.Context, rpcAddr string) (*client.Client, error) {
+	xc.mu.Lock()
+	p, ok := xc.pools[rpcAddr]
+	if !ok {
+		p = newPool(xc.poolOpt, func() (*client.Client, error) {
+			return client.XDial(rpcAddr, xc.opt)
+		})
+		xc.pools[rpcAddr] = p
+	}
+	xc.mu.Unlock()
+	return p.get(ctx)
+}
+
+// selectServer picks the server for the next call according to the select mode.
+func (xc *XClient) selectServer(ctx context.Context) (string, error) {
+	switch xc.mode {
+	case LeastOutstandingSelect:
+		return xc.leastOutstanding()
+	case ConsistentHashSelect:
+		if key, ok := HashKeyFromContext(ctx); ok {
+			return xc.consistentHash(key)
+		}
+		return xc.d.Get(RandomSelect)
+	case P2CSelect:
+		return xc.p2c()
+	}
+	return xc.d.Get(xc.mode)
+}
+
+// consistentHash returns the server owning key, rebuilding the ring when the
+// server list reported by discovery has changed.
+func (xc *XClient) consistentHash(key string) (string, error) {
+	servers, err := xc.d.GetAll()
+	if err != nil {
+		return "", err
+	}
+	if len(servers) == 0 {
+		return "", errs.ErrNoAvailableServers
+	}
 	xc.mu.Lock()
 	defer xc.mu.Unlock()
-	cli, ok := xc.clients[rpcAddr]
-	if ok && !cli.IsAvailable() {
-		_ = cli.Close()
-		delete(xc.clients, rpcAddr)
-		cli = nil
-	}
-	if cli == nil {
-		var err error
-		cli, err = client.XDial(rpcAddr, xc.opt)
-		if err != nil {
-			return nil, err
+	if xc.ring == nil || !xc.ring.matches(servers) {
+		xc.ring = newHashRing(servers, DefaultHashReplicas)
+	}
+	return xc.ring.get(key), nil
+}
+
+// leastOutstanding picks the server with the fewest pending calls over its pooled
+// connections, servers without connections count as idle. Ties are broken at random.
+func (xc *XClient) leastOutstanding() (string, error) {
+	servers, err := xc.d.GetAll()
+	if err != nil {
+		return "", err
+	}
+	if len(servers) == 0 {
+		return "", errs.ErrNoAvailableServers
+	}
+	xc.mu.Lock()
+	pools := make([]*pool, len(servers))
+	for i, s := range servers {
+		pools[i] = xc.pools[s]
+	}
+	xc.mu.Unlock()
+
+	best, bestPending, ties := "", 0, 0
+	for i, s := range servers {
+		n := 0
+		if pools[i] != nil {
+			n = pools[i].pending()
+		}
+		switch {
+		case best == "" || n < bestPending:
+			best, bestPending, ties = s, n, 1
+		case n == bestPending:
+			ties++
+			if rand.IntN(ties) == 0 {
+				best = s
+			}
 		}
-		xc.clients[rpcAddr] = cli
 	}
-	return cli, nil
+	return best, nil
 }
 
-func (xc *XClient) call(ctx context.Context, rpcAddr string, serviceMethod string, args, reply interface{}) error {
-	client, err := xc.dial(rpcAddr)
+// evictIdle periodically closes idle pooled connections until Close.
+func (xc *XClient) evictIdle(timeout time.Duration) {
+	ticker := time.NewTicker(max(timeout/2, 10*time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			xc.mu.Lock()
+			pools := make([]*pool, 0, len(xc.pools))
+			for _, p := range xc.pools {
+				pools = append(pools, p)
+			}
+			xc.mu.Unlock()
+			for _, p := range pools {
+				p.evictIdle(now)
+			}
+		case <-xc.stop:
+			return
+		}
+	}
+}
+
+// registry returns the metrics registry configured in the dial option, nil means
+// metrics.DefaultRegistry.
+func (xc *XClient) registry() *metrics.Registry {
+	if xc.opt == nil {
+		return nil
+	}
+	return xc.opt.Metrics
+}
+
+// tracer returns the tracer configured in the dial option, nil disables tracing.
+func (xc *XClient) tracer() *trace.Tracer {
+	if xc.opt == nil {
+		return nil
+	}
+	return xc.opt.Tracer
+}
+
+// call invokes serviceMethod on rpcAddr inside a span recording the chosen server,
+// the client span created by client.Client becomes its child. With P2CSelect the
+// latency and outcome are recorded in the server's stats. dialed reports whether a
+// connection was obtained, the request was never sent otherwise.
+func (xc *XClient) call(ctx context.Context, rpcAddr string, serviceMethod string, args, reply interface{}) (dialed bool, err error) {
+	ctx, span := xc.tracer().Start(ctx, serviceMethod, trace.KindInternal)
+	span.SetAttribute("xclient.server", rpcAddr)
+	defer func() { span.Finish(err) }()
+	done := func(error) {}
+	if xc.mode == P2CSelect {
+		done = xc.stats(rpcAddr).begin()
+	}
+
+	client, err := xc.dial(ctx, rpcAddr)
 	if err != nil {
-		return err
+		// dial errors are plain network errors, record them as the server being unavailable
+		done(errs.NewStatus(errs.CodeUnavailable, err.Error()))
+		return false, err
+	}
+	defer func() { done(err) }()
+	return true, client.Call(ctx, serviceMethod, args, reply)
+}
+
+// Use appends interceptors wrapping Call and Broadcast, the first one is outermost.
+// It must be called before any call is made.
+func (xc *XClient) Use(interceptors ...client.UnaryClientInterceptor) {
+	xc.interceptors = append(xc.interceptors, interceptors...)
+}
+
+func (xc *XClient) intercept(ctx context.Context, serviceMethod string, args, reply interface{}, invoker client.UnaryInvoker) error {
+	if len(xc.interceptors) == 0 {
+		return invoker(ctx, serviceMethod, args, reply)
 	}
-	return client.Call(ctx, serviceMethod, args, reply)
+	return client.ChainInvoker(xc.interceptors, invoker)(ctx, serviceMethod, args, reply)
 }
 
 // Call invokes the named function, waits for it to complete,
 // and returns its error status.
-// xc will choose a proper server.
+// xc will choose a proper server and retry connection errors as the fail mode allows.
 func (xc *XClient) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	rpcAddr, err := xc.d.Get(xc.mode)
+	ctx = context.WithValue(ctx, callStateKey{}, newCallState())
+	if xc.retry == nil {
+		return xc.intercept(ctx, serviceMethod, args, reply, xc.invoke)
+	}
+	return xc.intercept(ctx, serviceMethod, args, reply, func(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+		return xc.retry(ctx, serviceMethod, args, reply, xc.invoke)
+	})
+}
+
+// Notify sends a one-way call to a server chosen by the select mode. It returns once
+// the request is written, the server runs the method without replying.
+func (xc *XClient) Notify(ctx context.Context, serviceMethod string, args interface{}) error {
+	return xc.intercept(ctx, serviceMethod, args, nil, xc.notify)
+}
+
+func (xc *XClient) notify(ctx context.Context, serviceMethod string, args, _ interface{}) (err error) {
+	rpcAddr, err := xc.selectServer(ctx)
 	if err != nil {
 		return err
 	}
-	return xc.call(ctx, rpcAddr, serviceMethod, args, reply)
+	ctx, span := xc.tracer().Start(ctx, serviceMethod, trace.KindInternal)
+	span.SetAttribute("xclient.server", rpcAddr)
+	defer func() { span.Finish(err) }()
+
+	client, err := xc.dial(ctx, rpcAddr)
+	if err != nil {
+		return err
+	}
+	return client.Notify(ctx, serviceMethod, args)
 }
 
+// Broadcast invokes the named function for every server registered in discovery.
+// The interceptors see a single invocation wrapping the whole fan-out.
 func (x *XClient) Broadcast(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	return x.intercept(ctx, serviceMethod, args, reply, x.broadcast)
+}
+
+func (x *XClient) broadcast(ctx context.Context, serviceMethod string, args, reply interface{}) error {
 	servers, err := x.d.GetAll()
 	if err != nil {
 		return err
 	}
+	// every per-server call becomes a child of the broadcast span
+	ctx, span := x.tracer().Start(ctx, serviceMethod, trace.KindInternal)
+	span.SetAttribute("xclient.broadcast", strconv.Itoa(len(servers)))
 
 	var wg sync.WaitGroup
 	var mu sync.Mutex
@@ -91,6 +295,7 @@ func (x *XClient) Broadcast(ctx context.Context, serviceMethod string, args, rep
 
 	replyDone := reply == nil
 	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	for _, rpcAddr := range servers {
 		wg.Add(1)
 		go func(rpcAddr string) {
@@ -99,7 +304,7 @@ func (x *XClient) Broadcast(ctx context.Context, serviceMethod string, args, rep
 			if reply != nil {
 				clonedReply = reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
 			}
-			err := x.call(ctx, rpcAddr, serviceMethod, args, clonedReply)
+			_, err := x.call(ctx, rpcAddr, serviceMethod, args, clonedReply)
 			mu.Lock()
 			if err != nil && e == nil {
 				e = err
@@ -113,5 +318,6 @@ func (x *XClient) Broadcast(ctx context.Context, serviceMethod string, args, rep
 		}(rpcAddr)
 	}
 	wg.Wait()
+	span.Finish(e)
 	return e
 }
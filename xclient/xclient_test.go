@@ -0,0 +1,149 @@
+package xclient
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/qiancijun/minirpc/client"
+	"github.com/qiancijun/minirpc/common"
+	"github.com/qiancijun/minirpc/server"
+	"github.com/qiancijun/minirpc/trace"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type Foo int
+
+type Args struct{ Num1, Num2 int }
+
+func (f Foo) Sum(args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+// touched 记录 Foo.Touch 收到的单向调用
+var touched = make(chan int, 10)
+
+func (f Foo) Touch(n int, reply *int) error {
+	touched <- n
+	return nil
+}
+
+// startServer 启动注册了 rcvr 的服务端，wrap 依次包装监听器，用来模拟出错的服务端
+func startServer(t testing.TB, rcvr interface{}, opts server.ServerOption, wrap ...func(net.Listener) net.Listener) string {
+	s := server.NewServer()
+	require.NoError(t, s.Register(rcvr))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := "tcp@" + l.Addr().String()
+	for _, w := range wrap {
+		l = w(l)
+	}
+	go s.Accept(l, opts)
+	return addr
+}
+
+func TestXClient_Interceptor(t *testing.T) {
+	d := NewMultiServersDiscovery([]string{startServer(t, new(Foo), server.DefaultServerOption), startServer(t, new(Foo), server.DefaultServerOption)})
+	xc := NewXClient(d, RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	var order []string
+	record := func(name string) client.UnaryClientInterceptor {
+		return func(ctx context.Context, serviceMethod string, args, reply interface{}, invoker client.UnaryInvoker) error {
+			order = append(order, name+" before "+serviceMethod)
+			err := invoker(ctx, serviceMethod, args, reply)
+			order = append(order, name+" after")
+			return err
+		}
+	}
+	xc.Use(record("a"), record("b"))
+
+	var reply int
+	require.NoError(t, xc.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply))
+	assert.Equal(t, 3, reply)
+	assert.Equal(t, []string{"a before Foo.Sum", "b before Foo.Sum", "b after", "a after"}, order)
+
+	// 广播只经过一次拦截器链
+	order = nil
+	require.NoError(t, xc.Broadcast(context.Background(), "Foo.Sum", &Args{Num1: 2, Num2: 3}, &reply))
+	assert.Equal(t, 5, reply)
+	assert.Equal(t, []string{"a before Foo.Sum", "b before Foo.Sum", "b after", "a after"}, order)
+}
+
+func TestXClient_Tracing(t *testing.T) {
+	exporter := &trace.InMemoryExporter{}
+	tracer := trace.NewTracer(exporter)
+	opts := server.ServerOption{Tracer: tracer}
+	d := NewMultiServersDiscovery([]string{startServer(t, new(Foo), opts), startServer(t, new(Foo), opts)})
+	xc := NewXClient(d, RoundRobinSelect, &common.Option{Tracer: tracer})
+	defer func() { _ = xc.Close() }()
+
+	var reply int
+	require.NoError(t, xc.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply))
+	spans := exporter.Spans()
+	require.Len(t, spans, 3)
+	// span 按结束顺序导出：服务端、客户端、XClient
+	srv, cli, call := spans[0], spans[1], spans[2]
+	assert.Equal(t, trace.KindServer, srv.Kind)
+	assert.Equal(t, trace.KindClient, cli.Kind)
+	assert.Equal(t, trace.KindInternal, call.Kind)
+	traceID := call.SpanContext().TraceID
+	for _, span := range spans {
+		assert.Equal(t, "Foo.Sum", span.Name)
+		assert.Equal(t, traceID, span.SpanContext().TraceID)
+	}
+	assert.Equal(t, call.SpanContext().SpanID, cli.Parent)
+	assert.Equal(t, cli.SpanContext().SpanID, srv.Parent)
+	assert.NotEmpty(t, call.Attributes()["xclient.server"])
+	assert.NotEmpty(t, srv.Attributes()["rpc.peer"])
+
+	exporter.Reset()
+	require.NoError(t, xc.Broadcast(context.Background(), "Foo.Sum", &Args{Num1: 2, Num2: 3}, &reply))
+	spans = exporter.Spans()
+	require.Len(t, spans, 7)
+	broadcast := spans[len(spans)-1]
+	assert.Equal(t, "2", broadcast.Attributes()["xclient.broadcast"])
+	servers := map[string]bool{}
+	for _, span := range spans[:len(spans)-1] {
+		assert.Equal(t, broadcast.SpanContext().TraceID, span.SpanContext().TraceID)
+		if span.Kind == trace.KindInternal {
+			assert.Equal(t, broadcast.SpanContext().SpanID, span.Parent)
+			servers[span.Attributes()["xclient.server"]] = true
+		}
+	}
+	assert.Len(t, servers, 2, "each server gets its own child span")
+}
+
+func TestXClient_Notify(t *testing.T) {
+	d := NewMultiServersDiscovery([]string{startServer(t, new(Foo), server.DefaultServerOption), startServer(t, new(Foo), server.DefaultServerOption)})
+	xc := NewXClient(d, RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	var seen []string
+	xc.Use(func(ctx context.Context, serviceMethod string, args, reply interface{}, invoker client.UnaryInvoker) error {
+		seen = append(seen, serviceMethod)
+		assert.Nil(t, reply)
+		return invoker(ctx, serviceMethod, args, reply)
+	})
+	for i := 0; i < 4; i++ {
+		require.NoError(t, xc.Notify(context.Background(), "Foo.Touch", i))
+	}
+	sum := 0
+	for i := 0; i < 4; i++ {
+		select {
+		case n := <-touched:
+			sum += n
+		case <-time.After(time.Second):
+			t.Fatal("one-way call was not executed")
+		}
+	}
+	assert.Equal(t, 6, sum)
+	assert.Equal(t, []string{"Foo.Touch", "Foo.Touch", "Foo.Touch", "Foo.Touch"}, seen)
+	// 轮询模式下两台服务器各自建立了连接
+	xc.mu.Lock()
+	assert.Len(t, xc.pools, 2)
+	xc.mu.Unlock()
+}